@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checkpointInterval is how often a changed checkpoint is written out.
+const checkpointInterval = 10 * time.Second
+
+// checkpoint tracks which CSV lines a run has finished with and saves the
+// highest line L such that every queued line <= L is done. Workers finish
+// jobs out of order, so a line is only covered once all earlier lines are.
+// A nil *checkpoint ignores everything.
+type checkpoint struct {
+	path    string
+	mu      sync.Mutex
+	pending []int // queued lines not yet done, ascending
+	last    int   // last line of the CSV
+	start   int   // line the run resumed from
+	written int
+}
+
+// readCheckpoint returns the line stored at path, or 0 if there is no file.
+func readCheckpoint(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad checkpoint in %s: %q", path, strings.TrimSpace(string(data)))
+	}
+	return n, nil
+}
+
+// newCheckpoint starts tracking the queued lines of a run over the CSV
+// lines after start up to end. Lines in that range that are not queued
+// count as done.
+func newCheckpoint(path string, start, end int, lines []int) *checkpoint {
+	pending := append([]int(nil), lines...)
+	sort.Ints(pending)
+	return &checkpoint{path: path, pending: pending, last: max(start, end), start: start, written: -1}
+}
+
+// done marks line as processed.
+func (c *checkpoint) done(line int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := sort.SearchInts(c.pending, line)
+	if i < len(c.pending) && c.pending[i] == line {
+		c.pending = append(c.pending[:i], c.pending[i+1:]...)
+	}
+}
+
+// mark is the line every queued line up to has been processed.
+func (c *checkpoint) mark() int {
+	if len(c.pending) == 0 {
+		return c.last
+	}
+	return max(c.start, c.pending[0]-1)
+}
+
+// save writes the current mark if it moved since the last save. The file
+// is replaced via rename so an interrupted write never leaves it empty.
+func (c *checkpoint) save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := c.mark()
+	if m == c.written {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(tmp, m); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	c.written = m
+	return nil
+}
+
+// run saves the checkpoint every checkpointInterval until stop is closed.
+func (c *checkpoint) run(stop <-chan struct{}) {
+	t := time.NewTicker(checkpointInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			if err := c.save(); err != nil {
+				mainLog.Warnf("cannot write checkpoint: %v", err)
+			}
+		}
+	}
+}
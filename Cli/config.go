@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// configEntry is one key = value line of a -config file. values holds the
+// elements of an array, in the form flag.Value.Set takes them.
+type configEntry struct {
+	line   int
+	key    string
+	values []string
+}
+
+var errUnterminatedArray = errors.New("unterminated array")
+
+// applyConfig sets the flags of fset named in the config file at path,
+// skipping those given on the command line, so the command line wins over
+// the file and the file over the defaults. Array values set a repeatable
+// flag once per element. It returns the names of the flags it set.
+func applyConfig(fset *flag.FlagSet, path string) (map[string]bool, error) {
+	entries, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	explicit := make(map[string]bool)
+	fset.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	seen := make(map[string]bool)
+	set := make(map[string]bool)
+	for _, e := range entries {
+		if fset.Lookup(e.key) == nil || e.key == "config" {
+			return nil, fmt.Errorf("%s:%d: unknown flag %q", path, e.line, e.key)
+		}
+		if seen[e.key] {
+			return nil, fmt.Errorf("%s:%d: %q is set twice", path, e.line, e.key)
+		}
+		seen[e.key] = true
+		if explicit[e.key] {
+			continue
+		}
+		for _, v := range e.values {
+			if err := fset.Set(e.key, v); err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid value %q for %s: %v", path, e.line, v, e.key, err)
+			}
+		}
+		set[e.key] = true
+	}
+	return set, nil
+}
+
+// logSettings logs the effective value of every flag of fset for -verbose,
+// with where it came from unless it is the default. The proxy may hold a
+// password, so only whether one is used is shown, and headers are shown by
+// name.
+func logSettings(fset *flag.FlagSet, fromConfig map[string]bool) {
+	fromArgs := make(map[string]bool)
+	fset.Visit(func(f *flag.Flag) { fromArgs[f.Name] = true })
+	fset.VisitAll(func(f *flag.Flag) {
+		v := strconv.Quote(f.Value.String())
+		switch f.Name {
+		case "proxy":
+			v = "none"
+			if f.Value.String() != "" {
+				v = "set"
+			}
+		case "add-header":
+			var names []string
+			for _, h := range *f.Value.(*stringList) {
+				name, _, _ := strings.Cut(h, ":")
+				names = append(names, strings.TrimSpace(name))
+			}
+			v = strconv.Quote(strings.Join(names, ", "))
+		}
+		switch {
+		case fromConfig[f.Name]:
+			v += " (from -config)"
+		case fromArgs[f.Name]:
+			v += " (from the command line)"
+		}
+		mainLog.Infof("setting -%s = %s", f.Name, v)
+	})
+}
+
+// readConfig parses the part of TOML a list of flags needs: key = value
+// lines with strings, numbers, booleans or arrays of those, and # comments.
+// Tables are rejected, as every key is a flag name.
+func readConfig(path string) ([]configEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	var entries []configEntry
+	for i := 0; i < len(lines); i++ {
+		start := i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			return nil, fmt.Errorf("%s:%d: tables are not supported, put every flag at the top level", path, start)
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: want key = value", path, start)
+		}
+		values, err := parseConfigValue(value)
+		// an array may continue over the following lines
+		for errors.Is(err, errUnterminatedArray) && i+1 < len(lines) {
+			i++
+			value += "\n" + lines[i]
+			values, err = parseConfigValue(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %v", path, start, key, err)
+		}
+		entries = append(entries, configEntry{line: start, key: key, values: values})
+	}
+	return entries, nil
+}
+
+// parseConfigValue parses the value of a config line, up to an optional
+// trailing comment.
+func parseConfigValue(s string) ([]string, error) {
+	s = strings.TrimLeft(s, " \t")
+	var values []string
+	if strings.HasPrefix(s, "[") {
+		s = s[1:]
+		for {
+			s = strings.TrimLeft(s, " \t\n,")
+			if strings.HasPrefix(s, "#") {
+				_, s, _ = strings.Cut(s, "\n")
+				continue
+			}
+			if s == "" {
+				return nil, errUnterminatedArray
+			}
+			if s[0] == ']' {
+				s = s[1:]
+				break
+			}
+			v, rest, err := parseConfigScalar(s)
+			if err != nil {
+				return nil, err
+			}
+			values, s = append(values, v), rest
+		}
+	} else {
+		v, rest, err := parseConfigScalar(s)
+		if err != nil {
+			return nil, err
+		}
+		values, s = []string{v}, rest
+	}
+	if s = strings.TrimSpace(s); s != "" && s[0] != '#' {
+		return nil, fmt.Errorf("unexpected %q after the value", s)
+	}
+	return values, nil
+}
+
+// parseConfigScalar parses a string, number or boolean at the start of s
+// and returns it with the rest of s.
+func parseConfigScalar(s string) (value, rest string, err error) {
+	switch {
+	case strings.HasPrefix(s, "'"):
+		// literal string, no escapes
+		end := strings.IndexAny(s[1:], "'\n")
+		if end < 0 || s[1+end] != '\'' {
+			return "", "", errors.New("unterminated string")
+		}
+		return s[1 : 1+end], s[end+2:], nil
+	case strings.HasPrefix(s, `"`):
+		for i := 1; i < len(s) && s[i] != '\n'; i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				v, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return "", "", fmt.Errorf("bad string %s", s[:i+1])
+				}
+				return v, s[i+1:], nil
+			}
+		}
+		return "", "", errors.New("unterminated string")
+	}
+	end := strings.IndexAny(s, " \t\n,]#")
+	if end < 0 {
+		end = len(s)
+	}
+	tok := s[:end]
+	if tok == "true" || tok == "false" {
+		return tok, s[end:], nil
+	}
+	num := strings.ReplaceAll(tok, "_", "")
+	if _, err := strconv.ParseFloat(num, 64); err != nil || tok == "" {
+		return "", "", fmt.Errorf("want a number, true, false or a quoted string, got %q", tok)
+	}
+	return num, s[end:], nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runConvert transcodes the primary files of tracks already in the DB into
+// other formats with ffmpeg, so a format can be added without downloading
+// anything again. Outputs that already exist are only recorded.
+func runConvert(args []string) {
+	fset := flag.NewFlagSet("convert", flag.ExitOnError)
+	dbPath := dbFlag(fset)
+	formats := fset.String("formats", "", "comma separated formats to produce, e.g. opus,flac")
+	outDir := fset.String("outdir", "", "directory for the new files (default: next to each source file)")
+	status := statusFlag(fset, statusDownloaded, "only convert tracks with this status")
+	dirMode := fset.String("dir-mode", "", "octal permissions for -outdir and parents it creates, e.g. 0775 (default: 0755 minus the umask)")
+	backupDB := fset.Bool("backup-db", true, "copy the DB to <db>.bak-<timestamp> before upgrading its schema")
+	_ = fset.Parse(args)
+
+	if *formats == "" {
+		fmt.Println("convert: -formats is required")
+		os.Exit(2)
+	}
+	formatList, err := parseFormats(*formats)
+	if err != nil {
+		fmt.Println("invalid -formats:", err)
+		os.Exit(1)
+	}
+	dirPerm, err := parseDirMode(*dirMode)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *outDir != "" {
+		if err := mkdirAll(*outDir, dirPerm); err != nil {
+			fmt.Println("cannot create output dir:", err)
+			os.Exit(1)
+		}
+	}
+
+	db, err := ensureDB(*dbPath, *backupDB)
+	if err != nil {
+		fmt.Println("db error:", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	type source struct{ id, path string }
+	rows, err := db.Query("SELECT ytdlp_id, mp3_path FROM tracks WHERE status = ? AND COALESCE(ytdlp_id, '') <> '' AND COALESCE(mp3_path, '') <> '' ORDER BY id", *status)
+	if err != nil {
+		fmt.Println("db error:", err)
+		os.Exit(1)
+	}
+	var sources []source
+	for rows.Next() {
+		var s source
+		if err := rows.Scan(&s.id, &s.path); err != nil {
+			fmt.Println("db error:", err)
+			os.Exit(1)
+		}
+		sources = append(sources, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		fmt.Println("db error:", err)
+		os.Exit(1)
+	}
+
+	converted, present, failed := 0, 0, 0
+	for _, s := range sources {
+		fi, err := os.Stat(s.path)
+		if err != nil {
+			fmt.Println("[convert] missing source:", sanitizeForLog(s.path))
+			failed++
+			continue
+		}
+		ext := filepath.Ext(s.path)
+		stem := strings.TrimSuffix(filepath.Base(s.path), ext)
+		dir := filepath.Dir(s.path)
+		if *outDir != "" {
+			dir = *outDir
+		}
+		for _, format := range formatList {
+			if strings.EqualFold(strings.TrimPrefix(ext, "."), format) {
+				continue
+			}
+			dst := filepath.Join(dir, stem+"."+format)
+			if _, err := os.Stat(dst); err == nil {
+				present++
+			} else {
+				// ffmpeg picks the muxer from the extension, so the temp
+				// name has to end in it; renamed once the file is complete
+				tmp := filepath.Join(dir, stem+".converting."+format)
+				if err := convertAudio(s.path, tmp, format); err != nil {
+					_ = os.Remove(tmp)
+					fmt.Println("[convert]", sanitizeForLog(s.path)+":", sanitizeForLog(err.Error()))
+					failed++
+					continue
+				}
+				_ = os.Chtimes(tmp, fi.ModTime(), fi.ModTime())
+				if err := os.Rename(tmp, dst); err != nil {
+					_ = os.Remove(tmp)
+					fmt.Println("[convert] cannot move", sanitizeForLog(dst)+":", err)
+					failed++
+					continue
+				}
+				converted++
+			}
+			if err := recordTrackFile(db, s.id, format, dst); err != nil {
+				fmt.Println("db error:", err)
+				os.Exit(1)
+			}
+		}
+	}
+	fmt.Printf("[convert] converted %d files, %d already present, %d failed\n", converted, present, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
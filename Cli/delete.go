@@ -0,0 +1,182 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// doomedTrack is a row `delete` removes, with the files that go with it.
+type doomedTrack struct {
+	id           int64
+	ytdlpID, url string
+	title        string
+	files        []string
+}
+
+// runDelete removes tracks from the DB together with their files: the
+// primary file, its info.json (and -write-result file) in -datadir and
+// everything in track_files. Without -yes it only shows what would be
+// removed.
+func runDelete(args []string) {
+	fset := flag.NewFlagSet("delete", flag.ExitOnError)
+	dbPath := dbFlag(fset)
+	dataDir := pathFlag(fset, "datadir", envDataDir, defaultDataDir, "directory with the info.json files")
+	id := fset.Int64("id", 0, "delete the track with this row id (the id column of export)")
+	status := statusFlag(fset, "", "delete every track with this status, e.g. failed")
+	yes := fset.Bool("yes", false, "really delete; without it the tracks are only listed")
+	backupDB := fset.Bool("backup-db", true, "copy the DB to <db>.bak-<timestamp> before upgrading its schema")
+	_ = fset.Parse(args)
+
+	if *id <= 0 && *status == "" {
+		fmt.Println("delete needs -id or -status")
+		os.Exit(2)
+	}
+	if _, err := os.Stat(*dbPath); err != nil {
+		fmt.Println("db error:", err)
+		os.Exit(1)
+	}
+	db, err := ensureDB(*dbPath, *backupDB)
+	if err != nil {
+		fmt.Println("db error:", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	tracks, err := tracksToDelete(db, *dataDir, *id, *status)
+	if err != nil {
+		fmt.Println("db error:", err)
+		os.Exit(1)
+	}
+
+	verb := "would remove"
+	if *yes {
+		verb = "removed"
+	}
+	var files int
+	var bytes int64
+	for _, t := range tracks {
+		name := t.title
+		if name == "" {
+			name = t.url
+		}
+		var size int64
+		var present []string
+		for _, p := range t.files {
+			if fi, err := os.Stat(p); err == nil {
+				size += fi.Size()
+				present = append(present, p)
+			}
+		}
+		if *yes {
+			if err := deleteTrackRow(db, t); err != nil {
+				fmt.Println("db error:", err)
+				os.Exit(1)
+			}
+			// the row goes first, so a file that cannot be removed is
+			// never left behind with a row pointing at it
+			for _, p := range present {
+				if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					fmt.Printf("[delete] cannot remove %s: %v\n", sanitizeForLog(p), err)
+				}
+			}
+		}
+		files += len(present)
+		bytes += size
+		fmt.Printf("[delete] %s #%d %s (%d files, %s)\n", verb, t.id, sanitizeForLog(name), len(present), formatBytes(size))
+	}
+
+	fmt.Printf("[delete] %s %d tracks and %d files, %s\n", verb, len(tracks), files, formatBytes(bytes))
+	if !*yes && len(tracks) > 0 {
+		fmt.Println("[delete] nothing was deleted; run again with -yes to delete them")
+	}
+}
+
+// tracksToDelete looks up the rows matching id and status, and the paths of
+// their files.
+func tracksToDelete(db *sql.DB, dataDir string, id int64, status Status) ([]doomedTrack, error) {
+	query := "SELECT id, COALESCE(ytdlp_id, ''), COALESCE(url, ''), COALESCE(title, ''), COALESCE(mp3_path, '') FROM tracks WHERE 1 = 1"
+	var params []any
+	if id > 0 {
+		query += " AND id = ?"
+		params = append(params, id)
+	}
+	if status != "" {
+		query += " AND status = ?"
+		params = append(params, status)
+	}
+	rows, err := db.Query(query+" ORDER BY id", params...)
+	if err != nil {
+		return nil, err
+	}
+	var tracks []doomedTrack
+	for rows.Next() {
+		var t doomedTrack
+		var mp3Path string
+		if err := rows.Scan(&t.id, &t.ytdlpID, &t.url, &t.title, &mp3Path); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if mp3Path != "" {
+			// the info.json shares the primary file's stem, see callYtDlp
+			stem := strings.TrimSuffix(filepath.Base(mp3Path), filepath.Ext(mp3Path))
+			infoPath := filepath.Join(dataDir, stem+".info.json")
+			t.files = append(t.files, mp3Path, infoPath, resultPath(infoPath))
+		}
+		tracks = append(tracks, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range tracks {
+		t := &tracks[i]
+		if t.ytdlpID == "" {
+			continue
+		}
+		rows, err := db.Query("SELECT path FROM track_files WHERE ytdlp_id = ?", t.ytdlpID)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var p string
+			if err := rows.Scan(&p); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			t.files = append(t.files, p)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return tracks, nil
+}
+
+// deleteTrackRow removes a track and the rows that belong to it.
+func deleteTrackRow(db *sql.DB, t doomedTrack) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM tags WHERE track_id = ?", t.id); err != nil {
+		return err
+	}
+	if t.ytdlpID != "" {
+		if _, err := tx.Exec("DELETE FROM track_files WHERE ytdlp_id = ?", t.ytdlpID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM tracks WHERE id = ?", t.id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
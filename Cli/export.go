@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// exportedTrack is one row of `export`.
+type exportedTrack struct {
+	ID           int64  `json:"id"`
+	YtdlpID      string `json:"ytdlp_id"`
+	URL          string `json:"url"`
+	Title        string `json:"title"`
+	Uploader     string `json:"uploader"`
+	Duration     int64  `json:"duration"`
+	Status       string `json:"status"`
+	DownloadedAt string `json:"downloaded_at"`
+}
+
+// runExport writes the tracks in the DB as CSV with a header row, or as a
+// JSON array.
+func runExport(args []string) {
+	fset := flag.NewFlagSet("export", flag.ExitOnError)
+	dbPath := dbFlag(fset)
+	format := fset.String("format", "csv", "output format: csv or json")
+	out := fset.String("o", "-", "output file, or - for stdout")
+	status := statusFlag(fset, "", "only export tracks with this status")
+	_ = fset.Parse(args)
+
+	if *format != "csv" && *format != "json" {
+		fmt.Fprintln(os.Stderr, "invalid -format, want csv or json:", *format)
+		os.Exit(2)
+	}
+	db, err := openExistingDB(*dbPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "db error:", err)
+		os.Exit(1)
+	}
+	tracks := []exportedTrack{}
+	if db != nil {
+		defer db.Close()
+		query := `SELECT id, COALESCE(ytdlp_id, ''), COALESCE(url, ''), COALESCE(title, ''), COALESCE(uploader, ''),
+			COALESCE(duration_seconds, 0), COALESCE(status, ''), COALESCE(downloaded_at, '') FROM tracks`
+		var params []any
+		if *status != "" {
+			query += " WHERE status = ?"
+			params = append(params, *status)
+		}
+		query += " ORDER BY id"
+		rows, err := db.Query(query, params...)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "db error:", err)
+			os.Exit(1)
+		}
+		for rows.Next() {
+			var t exportedTrack
+			if err := rows.Scan(&t.ID, &t.YtdlpID, &t.URL, &t.Title, &t.Uploader, &t.Duration, &t.Status, &t.DownloadedAt); err != nil {
+				fmt.Fprintln(os.Stderr, "db error:", err)
+				os.Exit(1)
+			}
+			tracks = append(tracks, t)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			fmt.Fprintln(os.Stderr, "db error:", err)
+			os.Exit(1)
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "cannot create output:", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	if *format == "json" {
+		enc := json.NewEncoder(bw)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(tracks)
+	} else {
+		err = writeExportCSV(bw, tracks)
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cannot write export:", err)
+		os.Exit(1)
+	}
+	if *out != "-" {
+		fmt.Printf("[export] wrote %d tracks to %s\n", len(tracks), *out)
+	}
+}
+
+func writeExportCSV(w io.Writer, tracks []exportedTrack) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"id", "ytdlp_id", "url", "title", "uploader", "duration", "status", "downloaded_at"}); err != nil {
+		return err
+	}
+	for _, t := range tracks {
+		record := []string{strconv.FormatInt(t.ID, 10), t.YtdlpID, t.URL, t.Title, t.Uploader, strconv.FormatInt(t.Duration, 10), t.Status, t.DownloadedAt}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// integratedLoudnessRe matches the "I: -14.2 LUFS" lines printed by the
+// ebur128 filter; the last one is the summary for the whole file.
+var integratedLoudnessRe = regexp.MustCompile(`I:\s+(-?[0-9.]+|-inf) LUFS`)
+
+// measureLoudness runs ffmpeg's ebur128 filter over path in analysis mode and
+// returns the integrated loudness in LUFS. The file is not modified.
+func measureLoudness(path string) (float64, error) {
+	cmd := exec.Command("ffmpeg", "-hide_banner", "-nostats", "-i", path, "-af", "ebur128", "-f", "null", "-")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("ffmpeg ebur128: %w", err)
+	}
+	matches := integratedLoudnessRe.FindAllSubmatch(out, -1)
+	if len(matches) == 0 {
+		return 0, errors.New("no integrated loudness in ffmpeg output")
+	}
+	lufs, err := strconv.ParseFloat(string(matches[len(matches)-1][1]), 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse loudness: %w", err)
+	}
+	if math.IsInf(lufs, 0) {
+		return 0, errors.New("track is silent")
+	}
+	return lufs, nil
+}
+
+// audioCodecArgs holds the ffmpeg encoder settings for each supported format.
+// The keys are also the file extensions used for the output.
+var audioCodecArgs = map[string][]string{
+	"mp3":  {"-c:a", "libmp3lame", "-q:a", "0"},
+	"opus": {"-c:a", "libopus", "-b:a", "160k"},
+	"m4a":  {"-c:a", "aac", "-b:a", "256k"},
+	"flac": {"-c:a", "flac"},
+	"wav":  {"-c:a", "pcm_s16le"},
+}
+
+// audioQualityRe matches the values yt-dlp takes for --audio-quality: a VBR
+// level from 0 (best) to 10, or a bitrate in kbit/s like 128K.
+var audioQualityRe = regexp.MustCompile(`^(?:[0-9]|10|[1-9][0-9]{0,3}[kK])$`)
+
+// parseFormats splits a comma separated format list, dropping duplicates and
+// rejecting formats we cannot produce.
+func parseFormats(list string) ([]string, error) {
+	var formats []string
+	seen := make(map[string]bool)
+	for _, f := range strings.Split(list, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" || seen[f] {
+			continue
+		}
+		if _, ok := audioCodecArgs[f]; !ok {
+			return nil, fmt.Errorf("unsupported audio format %q", f)
+		}
+		seen[f] = true
+		formats = append(formats, f)
+	}
+	if len(formats) == 0 {
+		return nil, errors.New("no audio format given")
+	}
+	return formats, nil
+}
+
+// convertAudio transcodes src into dst in the given format, keeping metadata.
+func convertAudio(src, dst, format string) error {
+	args := []string{"-hide_banner", "-loglevel", "error", "-y", "-i", src, "-vn", "-map_metadata", "0"}
+	args = append(args, audioCodecArgs[format]...)
+	args = append(args, dst)
+	out, err := exec.Command("ffmpeg", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg convert to %s: %w: %s", format, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// uploaderFilter decides which uploaders' tracks are kept, from the
+// -allow-uploader and -deny-uploader flags. A pattern prefixed with "re:" is
+// a regular expression; anything else matches as a case-insensitive
+// substring. A nil filter allows everything.
+type uploaderFilter struct {
+	allow []func(string) bool
+	deny  []func(string) bool
+}
+
+func newUploaderFilter(allow, deny []string) (*uploaderFilter, error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+	f := &uploaderFilter{}
+	var err error
+	if f.allow, err = compileUploaderPatterns(allow); err != nil {
+		return nil, err
+	}
+	if f.deny, err = compileUploaderPatterns(deny); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func compileUploaderPatterns(patterns []string) ([]func(string) bool, error) {
+	var matchers []func(string) bool
+	for _, p := range patterns {
+		if expr, ok := strings.CutPrefix(p, "re:"); ok {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("uploader pattern %q: %w", p, err)
+			}
+			matchers = append(matchers, re.MatchString)
+			continue
+		}
+		needle := strings.ToLower(p)
+		matchers = append(matchers, func(s string) bool {
+			return strings.Contains(strings.ToLower(s), needle)
+		})
+	}
+	return matchers, nil
+}
+
+// check returns "" when uploader passes, or the reason it was rejected.
+// Deny patterns win over allow patterns.
+func (f *uploaderFilter) check(uploader string) string {
+	if f == nil {
+		return ""
+	}
+	for _, m := range f.deny {
+		if m(uploader) {
+			return "uploader denied: " + uploader
+		}
+	}
+	if len(f.allow) == 0 {
+		return ""
+	}
+	for _, m := range f.allow {
+		if m(uploader) {
+			return ""
+		}
+	}
+	return "uploader not allowed: " + uploader
+}
+
+// missingFields returns the fields of the info JSON raw that are absent,
+// null, empty strings or empty lists.
+func missingFields(raw string, fields []string) []string {
+	var info map[string]any
+	_ = json.Unmarshal([]byte(raw), &info)
+	var missing []string
+	for _, f := range fields {
+		switch v := info[f].(type) {
+		case nil:
+		case string:
+			if strings.TrimSpace(v) != "" {
+				continue
+			}
+		case []any:
+			if len(v) > 0 {
+				continue
+			}
+		default:
+			continue
+		}
+		missing = append(missing, f)
+	}
+	return missing
+}
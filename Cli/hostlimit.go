@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// hostLimiter caps how many jobs talk to the same site at once for
+// -per-host, independently of -workers. A nil *hostLimiter does not limit.
+type hostLimiter struct {
+	mu    sync.Mutex
+	max   int
+	slots map[string]chan struct{}
+}
+
+func newHostLimiter(max int) *hostLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &hostLimiter{max: max, slots: make(map[string]chan struct{})}
+}
+
+// acquire blocks until the host of rawURL has a free slot and returns the
+// function that frees it again.
+func (h *hostLimiter) acquire(rawURL string) (release func()) {
+	if h == nil {
+		return func() {}
+	}
+	host := siteHost(rawURL)
+	h.mu.Lock()
+	sem := h.slots[host]
+	if sem == nil {
+		sem = make(chan struct{}, h.max)
+		h.slots[host] = sem
+	}
+	h.mu.Unlock()
+	sem <- struct{}{}
+	return func() { <-sem }
+}
+
+// siteHost is the host -per-host counts rawURL against: lower-cased, without
+// "www."/"m.", with youtu.be counted as youtube.com. URLs without a host
+// share the key "".
+func siteHost(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return ""
+	}
+	host := strings.ToLower(u.Hostname())
+	host = strings.TrimPrefix(host, "www.")
+	host = strings.TrimPrefix(host, "m.")
+	if host == "youtu.be" || host == "music.youtube.com" {
+		host = "youtube.com"
+	}
+	return host
+}
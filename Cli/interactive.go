@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// prompter asks the user how to handle ambiguous inputs in -interactive
+// mode. Workers share one prompter, so prompts never overlap. A nil
+// *prompter never asks, and every question gets its default answer.
+type prompter struct {
+	mu  sync.Mutex
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// newPrompter returns a prompter reading from the terminal, or nil when
+// prompting is off: -interactive not given, -yes given, or stdin not a
+// terminal (e.g. when piping URLs in or running from cron).
+func newPrompter(interactive, yes bool) *prompter {
+	if !interactive || yes || !isTerminal(os.Stdin) {
+		return nil
+	}
+	return &prompter{in: bufio.NewReader(os.Stdin), out: os.Stdout}
+}
+
+// isTerminal reports whether f looks like a terminal: a character device
+// other than the null device, which is what stdin is under cron and
+// `</dev/null`.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(fi, null)
+}
+
+// choose shows question with numbered choices and returns the index of the
+// one picked. An empty answer, end of input, or a nil prompter picks def.
+func (p *prompter) choose(question string, choices []string, def int) int {
+	if p == nil {
+		return def
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintln(p.out, question)
+	for i, c := range choices {
+		mark := " "
+		if i == def {
+			mark = "*"
+		}
+		fmt.Fprintf(p.out, " %s%d) %s\n", mark, i+1, c)
+	}
+	for {
+		fmt.Fprintf(p.out, "choice [%d]: ", def+1)
+		line, err := p.in.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if err != nil {
+				fmt.Fprintln(p.out)
+			}
+			return def
+		}
+		if n, convErr := strconv.Atoi(line); convErr == nil && n >= 1 && n <= len(choices) {
+			return n - 1
+		}
+		if err != nil {
+			return def
+		}
+		fmt.Fprintf(p.out, "please enter a number from 1 to %d\n", len(choices))
+	}
+}
+
+// resolve asks about a probed URL that is a playlist or offers several audio
+// formats, updating url and opts with the answers. skip is true when the
+// user chose not to download it.
+func (p *prompter) resolve(info YtdlpInfo, url *string, opts *Options) (skip bool) {
+	if p == nil {
+		return false
+	}
+	name := sanitizeForLog(*url)
+	if info.Type == "playlist" && len(info.Entries) > 0 {
+		first := info.Entries[0]
+		choices := []string{
+			fmt.Sprintf("download all %d entries", len(info.Entries)),
+			"only the first entry: " + sanitizeForLog(first.Title),
+			"skip",
+		}
+		switch p.choose(name+" is a playlist:", choices, 0) {
+		case 1:
+			if first.URL != "" {
+				*url = first.URL
+			}
+		case 2:
+			return true
+		}
+		return false
+	}
+
+	// the format list only offers audio-only formats
+	if opts.FormatID != "" || opts.Video {
+		return false
+	}
+	var audio []ytdlpFormat
+	for _, f := range info.Formats {
+		if f.VCodec == "none" && f.ACodec != "" && f.ACodec != "none" {
+			audio = append(audio, f)
+		}
+	}
+	if len(audio) < 2 {
+		return false
+	}
+	choices := []string{"best audio (default)"}
+	for _, f := range audio {
+		choices = append(choices, fmt.Sprintf("%s: %s %s, %.0f kbps", f.FormatID, f.Ext, f.ACodec, f.ABR))
+	}
+	choices = append(choices, "skip")
+	switch i := p.choose(name+" has several audio formats:", choices, 0); {
+	case i == len(choices)-1:
+		return true
+	case i > 0:
+		// still converted to -formats like the default pick
+		opts.FormatID = audio[i-1].FormatID
+		opts.FormatIDExtract = true
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// listedTrack is one row of `list -json`.
+type listedTrack struct {
+	ID       string  `json:"id"`
+	URL      string  `json:"url"`
+	Title    string  `json:"title"`
+	Uploader string  `json:"uploader"`
+	Duration float64 `json:"duration_seconds"`
+	Status   string  `json:"status"`
+	// DownloadMS is how long yt-dlp ran, nil when unknown
+	DownloadMS *int64 `json:"download_ms"`
+}
+
+// runList prints the tracks in the DB as a table, or as JSON with -json.
+func runList(args []string) {
+	fset := flag.NewFlagSet("list", flag.ExitOnError)
+	dbPath := dbFlag(fset)
+	status := statusFlag(fset, "", "only list tracks with this status, e.g. failed")
+	asJSON := fset.Bool("json", false, "print a JSON array instead of a table")
+	var tags stringList
+	fset.Var(&tags, "tag", "only list tracks with this tag; repeat to require several")
+	_ = fset.Parse(args)
+
+	// listing must not create or upgrade the DB it is asked about
+	db, err := openExistingDB(*dbPath)
+	if err != nil {
+		fmt.Println("db error:", err)
+		os.Exit(1)
+	}
+	tracks := []listedTrack{}
+	if db != nil {
+		defer db.Close()
+		if len(tags) > 0 {
+			if err := checkTagsTable(db); err != nil {
+				fmt.Println("db error:", err)
+				os.Exit(1)
+			}
+		}
+		took, err := downloadMSColumn(db)
+		if err != nil {
+			fmt.Println("db error:", err)
+			os.Exit(1)
+		}
+		query := "SELECT COALESCE(ytdlp_id, ''), COALESCE(url, ''), COALESCE(title, ''), COALESCE(uploader, ''), COALESCE(duration_seconds, 0), COALESCE(status, ''), " + took + " FROM tracks WHERE 1 = 1"
+		var params []any
+		if *status != "" {
+			query += " AND status = ?"
+			params = append(params, *status)
+		}
+		query, params = withTags(query, params, tags)
+		query += " ORDER BY id"
+		rows, err := db.Query(query, params...)
+		if err != nil {
+			fmt.Println("db error:", err)
+			os.Exit(1)
+		}
+		for rows.Next() {
+			var t listedTrack
+			if err := rows.Scan(&t.ID, &t.URL, &t.Title, &t.Uploader, &t.Duration, &t.Status, &t.DownloadMS); err != nil {
+				fmt.Println("db error:", err)
+				os.Exit(1)
+			}
+			tracks = append(tracks, t)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			fmt.Println("db error:", err)
+			os.Exit(1)
+		}
+	}
+
+	printTracks(tracks, *asJSON)
+}
+
+// checkTagsTable reports a DB from before tags were stored, which the
+// read-only commands cannot upgrade themselves.
+func checkTagsTable(db *sql.DB) error {
+	ok, err := hasTable(db, "tags")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("this DB has no tags yet; run verify or a download on it once to upgrade it")
+	}
+	return nil
+}
+
+// downloadMSColumn returns what to select for download_ms: the column, or
+// NULL in a DB from before it was added.
+func downloadMSColumn(db *sql.DB) (string, error) {
+	ok, err := hasColumn(db, "tracks", "download_ms")
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "NULL", nil
+	}
+	return "download_ms", nil
+}
+
+// withTags narrows a tracks query to the tracks carrying every one of tags.
+func withTags(query string, params []any, tags []string) (string, []any) {
+	for _, tag := range tags {
+		query += " AND id IN (SELECT track_id FROM tags WHERE tag = ?)"
+		params = append(params, strings.TrimSpace(tag))
+	}
+	return query, params
+}
+
+// printTracks writes tracks to stdout as a table, or as a JSON array.
+func printTracks(tracks []listedTrack, asJSON bool) {
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(tracks); err != nil {
+			fmt.Fprintln(os.Stderr, "cannot write JSON:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITLE\tUPLOADER\tDURATION\tSTATUS\tTOOK")
+	for _, t := range tracks {
+		id, title := t.ID, t.Title
+		if id == "" {
+			id = "-"
+		}
+		if title == "" {
+			// failed rows often have nothing but the URL to go by
+			title = t.URL
+		}
+		took := "-"
+		if t.DownloadMS != nil {
+			took = msDuration(*t.DownloadMS)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", sanitizeForLog(id), sanitizeForLog(title), sanitizeForLog(t.Uploader), formatDuration(t.Duration), sanitizeForLog(t.Status), took)
+	}
+	_ = tw.Flush()
+}
+
+// formatDuration renders seconds as m:ss, or h:mm:ss from an hour up.
+func formatDuration(seconds float64) string {
+	s := int64(seconds + 0.5)
+	if s <= 0 {
+		return "-"
+	}
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
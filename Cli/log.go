@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// jsonLog mirrors every runLog line as a JSON record when -json-log-file is
+// set; nil otherwise.
+var jsonLog *slog.Logger
+
+// consoleLog replaces the text lines on stdout with JSON records under
+// -log-format json; nil otherwise.
+var consoleLog *slog.Logger
+
+// setLogFormat picks how runLog lines are printed to stdout: "text" for
+// prefixed lines, or "json" for one JSON object per line with level,
+// worker, url, msg and ts.
+func setLogFormat(format string) error {
+	switch format {
+	case "text":
+		consoleLog = nil
+	case "json":
+		consoleLog = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+			Level: slog.LevelDebug,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && a.Key == slog.TimeKey {
+					a.Key = "ts"
+				}
+				return a
+			},
+		}))
+	default:
+		return fmt.Errorf("want text or json, got %q", format)
+	}
+	return nil
+}
+
+// toolStdout is where the output of yt-dlp goes. It is stdout, except under
+// -log-format json where stdout is reserved for log records, and with
+// -progress where it would garble the bar.
+func toolStdout() io.Writer {
+	switch {
+	case consoleLog != nil:
+		return os.Stderr
+	case bar != nil:
+		return io.Discard
+	}
+	return os.Stdout
+}
+
+// runLog prints progress lines for one component ("[worker 2]", "[main]",
+// "[db]") to stdout, as text or through consoleLog, and mirrors them to
+// jsonLog with the component's attributes attached.
+type runLog struct {
+	prefix string
+	attrs  []any
+}
+
+var (
+	mainLog = runLog{prefix: "[main]", attrs: []any{"component", "main"}}
+	dbLog   = runLog{prefix: "[db]", attrs: []any{"component", "db"}}
+)
+
+func workerLog(id int) runLog {
+	return runLog{prefix: fmt.Sprintf("[worker %d]", id), attrs: []any{"component", "worker", "worker", id}}
+}
+
+// withURL returns a copy of l that tags JSON records with url.
+func (l runLog) withURL(url string) runLog {
+	l.attrs = append(append([]any(nil), l.attrs...), "url", url)
+	return l
+}
+
+func (l runLog) Infof(format string, args ...any)  { l.logf(slog.LevelInfo, format, args...) }
+func (l runLog) Warnf(format string, args ...any)  { l.logf(slog.LevelWarn, format, args...) }
+func (l runLog) Errorf(format string, args ...any) { l.logf(slog.LevelError, format, args...) }
+
+func (l runLog) logf(level slog.Level, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if consoleLog != nil {
+		consoleLog.Log(context.Background(), level, msg, l.attrs...)
+	} else if bar != nil {
+		bar.above(l.prefix + " " + msg)
+	} else {
+		fmt.Println(l.prefix, msg)
+	}
+	if jsonLog != nil {
+		jsonLog.Log(context.Background(), level, msg, l.attrs...)
+	}
+}
+
+// openJSONLog sets up jsonLog to append to path. Once the file grows past
+// maxBytes it is rotated to path+".1", replacing any previous rotation;
+// maxBytes <= 0 never rotates.
+func openJSONLog(path string, maxBytes int64) (func() error, error) {
+	w, err := newRotatingFile(path, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	jsonLog = slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	return w.Close, nil
+}
+
+// rotatingFile is an append-only file that rotates itself by size.
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	f        *os.File
+	size     int64
+}
+
+func newRotatingFile(path string, maxBytes int64) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxBytes: maxBytes}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	r.f, r.size = f, fi.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maxBytes > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxBytes {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
@@ -2,20 +2,31 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/fs"
+	"math/rand/v2"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
+	"unicode"
 
 	_ "modernc.org/sqlite"
 )
@@ -27,18 +38,334 @@ type YtdlpInfo struct {
 	Duration float64  `json:"duration"` // seconds
 	Tags     []string `json:"tags"`
 	Webpage  string   `json:"webpage_url"`
+	IsLive   bool     `json:"is_live"`
+	// YYYYMMDD as yt-dlp reports it
+	UploadDate string `json:"upload_date"`
+	ViewCount  int64  `json:"view_count"`
+	LikeCount  int64  `json:"like_count"`
+	// filled by probes only, for -interactive
+	Type    string          `json:"_type"`
+	Entries []playlistEntry `json:"entries"`
+	Formats []ytdlpFormat   `json:"formats"`
 	// store raw JSON too
 }
 
+// playlistEntry is one entry of a flat playlist probe.
+type playlistEntry struct {
+	URL   string `json:"url"`
+	Title string `json:"title"`
+}
+
+// ytdlpFormat is one of the formats yt-dlp lists for a video.
+type ytdlpFormat struct {
+	FormatID string  `json:"format_id"`
+	Ext      string  `json:"ext"`
+	ACodec   string  `json:"acodec"`
+	VCodec   string  `json:"vcodec"`
+	ABR      float64 `json:"abr"`
+}
+
+// Default locations, shared by the download run and the subcommands.
+const (
+	defaultDBPath  = "tracks.db"
+	defaultMp3Dir  = "./downloads/mp3"
+	defaultDataDir = "./data/json"
+)
+
 type Job struct {
-	URL string
+	URL      string
+	Priority int    // higher runs first
+	Line     int    // CSV line the URL came from, for -checkpoint
+	Notes    string // from -notes-column, stored with every row of the job
+	// Entry is the own page of a failed playlist entry retry-failed
+	// downloads in place of URL, and EntryID the ytdlp_id of its row
+	Entry, EntryID string
+}
+
+// page is the URL a job downloads: its Entry if it has one, else its URL.
+func (j Job) page() string {
+	if j.Entry != "" {
+		return j.Entry
+	}
+	return j.URL
+}
+
+// Live stream policies for -live-policy.
+const (
+	livePolicySkip      = "skip"
+	livePolicyFromStart = "from-start"
+	livePolicyWait      = "wait"
+)
+
+// retryBaseDelay is the wait before the first retry of a failed download;
+// it doubles with every further attempt.
+const retryBaseDelay = 2 * time.Second
+
+// limitRateRe matches -limit-rate values: bytes per second, optionally
+// with a K or M suffix.
+var limitRateRe = regexp.MustCompile(`^[0-9]+(?:\.[0-9]+)?[KkMm]?$`)
+
+// liveWaitInterval is how often the wait policy re-checks a running stream.
+const liveWaitInterval = 5 * time.Minute
+
+// Options holds the download settings shared by every worker.
+type Options struct {
+	YtDlp              string // yt-dlp executable, resolved at startup
+	Mp3Dir             string
+	DataDir            string
+	LivePolicy         string
+	ConflictPolicy     string
+	MeasureLoudness    bool     // run an ffmpeg EBU R128 analysis after each download
+	AudioQuality       string   // yt-dlp --audio-quality: 0-10 VBR or a bitrate like 128K
+	Formats            []string // audio formats to produce; the first is the primary file
+	UserAgent          string
+	Cookies            string   // Netscape cookies file for yt-dlp
+	CookiesFromBrowser string   // browser[:profile] to read cookies from
+	Headers            []string // extra HTTP headers as "Name:Value"
+	VerifyDownload     bool     // read each file back before recording success
+	MaxTagLength       int      // truncate embedded title/description tags to this many characters, 0 = no limit
+	FormatID           string   // exact yt-dlp format_id to download instead of the best audio
+	FormatSort         string   // yt-dlp --format-sort, e.g. "acodec:opus", "" for yt-dlp's default order
+	FormatIDExtract    bool     // still extract audio when FormatID is set
+	Uploaders          *uploaderFilter
+	EmbedMetadata      bool          // let yt-dlp write title/artist/... tags into the file
+	EmbedThumbnail     bool          // let yt-dlp embed the thumbnail as cover art
+	StrictAudio        bool          // reject downloads whose header is not a known audio format
+	RequireFields      []string      // info.json fields that must not be empty
+	DeleteIncomplete   bool          // delete the files of tracks missing RequireFields
+	Prompt             *prompter     // asks about playlists and formats with -interactive
+	Dests              []string      // extra directories that get a copy of every download
+	LimitRate          string        // yt-dlp --limit-rate per download, e.g. 500K
+	Timeout            time.Duration // kill yt-dlp after this long, 0 = never
+	Retries            int           // extra attempts after yt-dlp exits with an error
+	Proxies            *proxyPool    // rotation from -proxy-list
+	Stats              *runStats     // outcome counts for the end-of-run summary
+	NameTemplate       string        // yt-dlp output template for file names, without extension
+	ByUploader         bool          // put audio files in a subdirectory per uploader
+	Overwrite          bool          // download URLs again even when already downloaded
+	SkipFailed         bool          // also skip URLs with a failed row
+	RetryAfter         time.Duration // with SkipFailed, retry failed rows last tried this long ago
+	VerifyFiles        bool          // only skip downloaded URLs whose file still exists
+	Quiet              bool          // discard yt-dlp's output
+	Verbose            bool          // log each yt-dlp command line
+	Proxy              string        // -proxy, or the one picked from Proxies for this job
+	NoMtime            bool          // give files the download time instead of the upload time
+	NoHash             bool          // skip the sha256 of downloaded files
+	DedupContent       bool          // drop downloads whose sha256 is already in the DB
+	MatchFilter        string        // yt-dlp --match-filter, "" for none
+	PlaylistItems      string        // yt-dlp --playlist-items, e.g. "1-10,15", "" for every entry
+	DateAfter          string        // yt-dlp --dateafter as YYYYMMDD, "" for none
+	DateBefore         string        // yt-dlp --datebefore as YYYYMMDD, "" for none
+	CacheDir           string        // directory for the job dirs instead of the system temp dir
+	Archive            string        // yt-dlp --download-archive file, "" for none
+	KeepTemp           bool          // keep the job dir of a failed download to resume it
+	ExtraArgs          []string      // -ytdlp-arg values, passed after the built-in arguments
+	Video              bool          // download video with audio and keep it as-is
+	SponsorBlock       string        // yt-dlp --sponsorblock-remove categories, "" to keep everything
+	Section            string        // only download this time range, as START-END; see parseSection
+	DirMode            os.FileMode   // permissions of created directories, 0 for 0755 minus the umask
+	Hosts              *hostLimiter  // -per-host limit on jobs per site
+	Sleep              time.Duration // pause of a worker between downloads
+	SleepJitter        time.Duration // Sleep varies by up to this much either way
+	WriteResult        bool          // write a <name>.result.json next to each info.json
+}
+
+// sleepBetweenJobs picks how long a worker pauses before its next download:
+// Sleep plus or minus a random part of SleepJitter.
+func (o Options) sleepBetweenJobs() time.Duration {
+	d := o.Sleep
+	if o.SleepJitter > 0 {
+		d += time.Duration(rand.Int64N(int64(2*o.SleepJitter)+1)) - o.SleepJitter
+	}
+	return max(d, 0)
+}
+
+// extractAudio reports whether yt-dlp should convert the download to
+// Formats[0]. A requested format_id is kept as-is unless asked otherwise,
+// and -video never extracts.
+func (o Options) extractAudio() bool {
+	return !o.Video && (o.FormatID == "" || o.FormatIDExtract)
+}
+
+// stringList is a flag.Value collecting every use of a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ", ") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// splitList splits a comma separated flag value, dropping empty entries.
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Download describes the files callYtDlp produced for one URL.
+type Download struct {
+	ID       string
+	InfoPath string
+	Mp3Path  string            // primary audio file, in Formats[0]
+	Extra    map[string]string // additional format -> path
+	Err      error             // why this playlist entry could not be finished
+	Webpage  string            // the entry's own page, kept so retry-failed can retry it alone
 }
 
-func ensureDB(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite", dbPath)
+// A migration upgrades the schema by one version. The database's
+// user_version is the number of migrations applied to it.
+type migration func(tx *sql.Tx) error
+
+// migrations upgrade databases created by older versions, oldest first;
+// append new ones at the end and never reorder them. New databases get the
+// full schema from ensureDB and start at the latest version. A migration
+// that adds a column should also add it to the CREATE TABLE in ensureDB.
+var migrations = []migration{
+	// 1: columns added before the schema was versioned; an unversioned
+	// database can have any subset of them
+	addColumns(
+		column{"lufs", "REAL"},
+		column{"user_agent", "TEXT"},
+		column{"command", "TEXT"},
+		column{"format_id", "TEXT"},
+		column{"proxy", "TEXT"},
+		column{"audio_quality", "TEXT"},
+		column{"webpage_url", "TEXT"},
+		column{"upload_date", "TEXT"},
+		column{"view_count", "INTEGER"},
+		column{"like_count", "INTEGER"},
+	),
+	// 2
+	addColumns(column{"sha256", "TEXT"}),
+	// 3
+	addColumns(column{"duplicate_of", "INTEGER"}),
+	// 4
+	addColumns(column{"notes", "TEXT"}),
+	// 5
+	addColumns(column{"media_path", mediaPathDecl}),
+	// 6
+	addColumns(column{"sponsorblock", "TEXT"}),
+	// 7
+	addColumns(column{"section", "TEXT"}),
+	// 8: tags of the tracks downloaded so far, from their info.json
+	func(tx *sql.Tx) error {
+		if _, err := tx.Exec("CREATE TABLE IF NOT EXISTS tags (" + tagsTableDecl + ")"); err != nil {
+			return err
+		}
+		// a broken info_json must not abort the migration
+		_, err := tx.Exec(`INSERT OR IGNORE INTO tags (track_id, tag)
+			SELECT tracks.id, TRIM(j.value) FROM tracks,
+				json_each(CASE WHEN json_valid(info_json) THEN info_json ELSE '{}' END, '$.tags') AS j
+			WHERE j.type = 'text' AND TRIM(j.value) <> ''`)
+		return err
+	},
+	// 9
+	addColumns(column{"download_ms", "INTEGER"}),
+	// 10: URLs stored before they were canonicalized; hosts added to
+	// canonicalizers later need a migration of their own
+	canonicalizeURLs,
+	// 11: the CHECK constraint of status, as triggers
+	addStatusTriggers,
+}
+
+// canonicalizeURLs rewrites the url of tracks and track_attempts rows to
+// their canonicalURL, so the skip check finds them under the form the
+// input is queued with.
+func canonicalizeURLs(tx *sql.Tx) error {
+	for _, table := range []string{"tracks", "track_attempts"} {
+		rows, err := tx.Query(fmt.Sprintf("SELECT DISTINCT url FROM %s WHERE url IS NOT NULL", table))
+		if err != nil {
+			return err
+		}
+		rewrite := make(map[string]string)
+		for rows.Next() {
+			var u string
+			if err := rows.Scan(&u); err != nil {
+				rows.Close()
+				return err
+			}
+			if c := canonicalURL(u); c != u {
+				rewrite[u] = c
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for from, to := range rewrite {
+			if _, err := tx.Exec(fmt.Sprintf("UPDATE %s SET url = ? WHERE url = ?", table), to, from); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// tagsTableDecl are the columns of tags, one row per tag of a track. Tags
+// compare without regard to case.
+const tagsTableDecl = `
+		track_id INTEGER NOT NULL,
+		tag TEXT NOT NULL COLLATE NOCASE,
+		PRIMARY KEY (track_id, tag)
+	`
+
+// mediaPathDecl declares media_path, the name for mp3_path that also fits
+// the videos of -video. mp3_path stays the stored column, so older queries
+// keep working.
+const mediaPathDecl = "TEXT GENERATED ALWAYS AS (mp3_path) VIRTUAL"
+
+// column is a tracks column added by a migration.
+type column struct{ name, decl string }
+
+// addColumns returns a migration adding the given tracks columns, skipping
+// the ones that already exist.
+func addColumns(cols ...column) migration {
+	return func(tx *sql.Tx) error {
+		existing, err := tableColumns(tx, "tracks")
+		if err != nil {
+			return err
+		}
+		for _, c := range cols {
+			if existing[c.name] {
+				continue
+			}
+			if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE tracks ADD COLUMN %s %s", c.name, c.decl)); err != nil {
+				return fmt.Errorf("add column %s: %w", c.name, err)
+			}
+		}
+		return nil
+	}
+}
+
+// dbPragmas are applied to every connection ensureDB opens. WAL lets readers
+// (the skip checks) run while a worker writes, and busy_timeout makes a
+// connection wait up to 5s for a lock held by another process instead of
+// failing at once.
+const dbPragmas = "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+
+// ensureDB opens dbPath, creating the schema or upgrading an older one. When
+// backup is set, a copy of the database is written next to it before any
+// migration runs.
+func ensureDB(dbPath string, backup bool) (*sql.DB, error) {
+	dsn, err := sqliteURI(dbPath, dbPragmas)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, err
 	}
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'tracks'").Scan(&tables); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
 	schema := `CREATE TABLE IF NOT EXISTS tracks (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		ytdlp_id TEXT UNIQUE,
@@ -49,337 +376,2675 @@ func ensureDB(dbPath string) (*sql.DB, error) {
 		mp3_path TEXT,
 		info_json TEXT,
 		downloaded_at TEXT DEFAULT (datetime('now')),
-		status TEXT DEFAULT 'downloaded',
+		status TEXT DEFAULT 'downloaded' CHECK (` + statusCheck("status") + `),
+		error_text TEXT,
+		lufs REAL,
+		user_agent TEXT,
+		command TEXT,
+		format_id TEXT,
+		proxy TEXT,
+		audio_quality TEXT,
+		webpage_url TEXT,
+		upload_date TEXT,
+		view_count INTEGER,
+		like_count INTEGER,
+		sha256 TEXT,
+		duplicate_of INTEGER,
+		notes TEXT,
+		media_path ` + mediaPathDecl + `,
+		sponsorblock TEXT,
+		section TEXT,
+		download_ms INTEGER
+	);
+	CREATE TABLE IF NOT EXISTS track_files (
+		ytdlp_id TEXT NOT NULL,
+		format TEXT NOT NULL,
+		path TEXT NOT NULL,
+		PRIMARY KEY (ytdlp_id, path)
+	);
+	CREATE TABLE IF NOT EXISTS track_attempts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		ytdlp_id TEXT,
+		attempted_at TEXT NOT NULL,
+		duration_ms INTEGER NOT NULL,
 		error_text TEXT
 	);
+	CREATE TABLE IF NOT EXISTS tags (` + tagsTableDecl + `);
+	CREATE INDEX IF NOT EXISTS idx_tags_tag ON tags(tag);
+	CREATE TABLE IF NOT EXISTS meta (
+		key TEXT PRIMARY KEY,
+		value TEXT
+	);
 	CREATE INDEX IF NOT EXISTS idx_tracks_ytdlp_id ON tracks(ytdlp_id);
-	CREATE INDEX IF NOT EXISTS idx_tracks_url ON tracks(url);`
+	CREATE INDEX IF NOT EXISTS idx_tracks_url ON tracks(url);
+	CREATE INDEX IF NOT EXISTS idx_track_attempts_url ON track_attempts(url);`
 	_, err = db.Exec(schema)
 	if err != nil {
 		_ = db.Close()
 		return nil, err
 	}
+	if tables == 0 {
+		// the schema above is already the latest one
+		if _, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", len(migrations))); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+		return db, nil
+	}
+	if err := migrateDB(db, dbPath, backup); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
 	return db, nil
 }
 
-// moveFile attempts os.Rename, falls back to copy+remove if needed.
+// migrateDB runs the migrations the database has not seen yet in a single
+// transaction, so a failure leaves the database as it was.
+func migrateDB(db *sql.DB, dbPath string, backup bool) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		dbLog.Warnf("%s has schema version %d, newer than this program knows (%d)", dbPath, version, len(migrations))
+		return nil
+	}
+	if version == len(migrations) {
+		return nil
+	}
+
+	backupPath := ""
+	if backup {
+		backupPath = fmt.Sprintf("%s.bak-%s", dbPath, time.Now().Format("20060102-150405"))
+		// VACUUM INTO writes a consistent copy even while the DB is open
+		if _, err := db.Exec("VACUUM INTO ?", backupPath); err != nil {
+			return fmt.Errorf("backup before migration: %w", err)
+		}
+		dbLog.Infof("backed up %s to %s before migrating", dbPath, backupPath)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for v := version; v < len(migrations); v++ {
+		if err := migrations[v](tx); err != nil {
+			_ = tx.Rollback()
+			if backupPath != "" {
+				return fmt.Errorf("migration %d failed: %w (backup kept at %s)", v+1, err, backupPath)
+			}
+			return fmt.Errorf("migration %d failed: %w", v+1, err)
+		}
+	}
+	// PRAGMA does not take parameters; len(migrations) is ours
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", len(migrations))); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	dbLog.Infof("upgraded %s to schema version %d", dbPath, len(migrations))
+	return nil
+}
+
+// tableColumns returns the set of column names in table.
+func tableColumns(tx *sql.Tx, table string) (map[string]bool, error) {
+	// table_xinfo, unlike table_info, also lists generated columns
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_xinfo(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid, notNull, pk, hidden int
+			name, typ                string
+			dflt                     sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk, &hidden); err != nil {
+			return nil, err
+		}
+		cols[name] = true
+	}
+	return cols, rows.Err()
+}
+
+// rename is os.Rename; tests replace it to take moveFile's cross-device path.
+var rename = os.Rename
+
+// moveFile attempts os.Rename, falls back to copy+remove if needed. Either
+// way dst appears in one step (see copyFile).
 func moveFile(src, dst string) error {
 	if src == dst {
 		return nil
 	}
-	if err := os.Rename(src, dst); err == nil {
+	if err := rename(src, dst); err == nil {
 		return nil
 	}
-	// fallback copy
+	// across filesystems: copy, then remove the source only once the copy
+	// is complete; a failed copy leaves dst as it was
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	if err := os.Remove(src); err != nil {
+		return err
+	}
+	return nil
+}
+
+// parseDirMode parses a -dir-mode value for mkdirAll; "" gives 0, the
+// default mode.
+func parseDirMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	// the tool itself must be able to write into the directories
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0o777 || mode&0o700 != 0o700 {
+		return 0, fmt.Errorf("invalid -dir-mode, want octal permissions including rwx for the owner, like 0775: %v", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// mkdirAll is os.MkdirAll, except that with a non-zero mode the directories
+// it creates get exactly that mode, whatever the umask. Directories that
+// already exist are left alone.
+func mkdirAll(dir string, mode os.FileMode) error {
+	if mode == 0 {
+		return os.MkdirAll(dir, 0o755)
+	}
+	var created []string
+	for d := filepath.Clean(dir); ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil || filepath.Dir(d) == d {
+			break
+		}
+		created = append(created, d)
+	}
+	if err := os.MkdirAll(dir, mode); err != nil {
+		return err
+	}
+	for _, d := range created {
+		if err := os.Chmod(d, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createArchive creates an empty -archive file, and its directory, unless
+// it exists. yt-dlp would create it with its first download, but a file that
+// is there from the start can be shared right away.
+func createArchive(path string, dirMode os.FileMode) error {
+	if err := mkdirAll(filepath.Dir(path), dirMode); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// copyFile copies src to dst, keeping its mode and mtime. The copy is written
+// under a hidden temp name next to dst and renamed into place, so something
+// watching the directory never sees a partial file.
+func copyFile(src, dst string) error {
 	in, err := os.Open(src)
 	if err != nil {
 		return err
 	}
 	defer in.Close()
-	out, err := os.Create(dst)
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
 	if err != nil {
 		return err
 	}
+	tmp := out.Name()
+	defer os.Remove(tmp) // no-op once renamed
 	defer out.Close()
 	if _, err := io.Copy(out, in); err != nil {
 		return err
 	}
+	// the source is removed after a move, so the copy must be on disk
+	// before it is renamed into place
 	if err := out.Sync(); err != nil {
-		// ignore
+		return err
 	}
-	if err := in.Close(); err != nil {
-		// ignore
+	if err := out.Close(); err != nil {
+		return err
 	}
-	if err := os.Remove(src); err != nil {
+	// CreateTemp makes the file 0600; give it the mode of the original
+	if err := os.Chmod(tmp, fi.Mode().Perm()); err != nil {
 		return err
 	}
-	return nil
+	// a copy gets the current time; keep whatever mtime yt-dlp set
+	if err := os.Chtimes(tmp, fi.ModTime(), fi.ModTime()); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
 }
 
-// callYtDlp downloads audio only into a per-job temporary directory, then moves files to mp3Dir and dataDir.
-// Returns ytdlp id and final paths (infoPath, mp3Path).
-func callYtDlp(mp3Dir, dataDir, url string) (ytdlpID string, infoPath string, mp3Path string, err error) {
-	// create a unique temp dir (system temp) per job to avoid races and cross-filesystem issues.
-	tmpDir, err := os.MkdirTemp("", "ytjob-*")
-	if err != nil {
-		return "", "", "", fmt.Errorf("mkdtemp: %w", err)
+// buildYtDlpArgs assembles the yt-dlp arguments for downloading url into outTpl.
+func buildYtDlpArgs(opts Options, outTpl, url string) []string {
+	format := "bestaudio/best"
+	if opts.Video {
+		format = "bv*+ba/b"
+	}
+	if opts.FormatID != "" {
+		format = opts.FormatID
 	}
-	// ensure we cleanup temp dir if anything goes wrong; on success files will be moved out
-	defer func() {
-		_ = os.RemoveAll(tmpDir)
-	}()
-
-	outTpl := filepath.Join(tmpDir, "%(id)s.%(ext)s")
-
 	args := []string{
 		"--no-warnings",
-		"--format", "bestaudio/best",
-		"--extract-audio",
-		"--audio-format", "mp3",
-		"--audio-quality", "0", // best quality
+		"--format", format,
+	}
+	if opts.FormatSort != "" {
+		// ranks the formats --format picks from; extraction comes after
+		args = append(args, "--format-sort", opts.FormatSort)
+	}
+	if opts.extractAudio() {
+		args = append(args,
+			"--extract-audio",
+			"--audio-format", opts.Formats[0],
+			"--audio-quality", opts.AudioQuality,
+		)
+	}
+	args = append(args,
 		"--write-info-json",
 		"-o", outTpl,
-		url,
+	)
+	args = append(args, networkArgs(opts)...)
+	if opts.NoMtime {
+		args = append(args, "--no-mtime")
 	}
-
-	cmd := exec.Command("yt-dlp", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return "", "", "", fmt.Errorf("yt-dlp failed: %w", err)
+	if opts.LimitRate != "" {
+		args = append(args, "--limit-rate", opts.LimitRate)
 	}
-
-	// find .info.json in tmpDir
-	infoFiles, err := filepath.Glob(filepath.Join(tmpDir, "*.info.json"))
-	if err != nil || len(infoFiles) == 0 {
-		// fallback recursive scan
-		_ = filepath.WalkDir(tmpDir, func(p string, d fs.DirEntry, e error) error {
-			if e != nil {
-				return nil
-			}
-			if strings.HasSuffix(p, ".info.json") {
-				infoFiles = append(infoFiles, p)
-			}
-			return nil
-		})
+	if opts.MaxTagLength > 0 {
+		// meta_* fields take precedence over the originals when yt-dlp
+		// embeds tags, so only the tags are shortened, not filenames or the DB
+		for _, field := range []string{"title", "description"} {
+			args = append(args, "--parse-metadata", fmt.Sprintf("%s:(?s)(?P<meta_%s>.{0,%d})", field, field, opts.MaxTagLength))
+		}
 	}
-	if len(infoFiles) == 0 {
-		return "", "", "", errors.New("no .info.json produced by yt-dlp")
+	if opts.EmbedMetadata {
+		args = append(args, "--embed-metadata")
 	}
-
-	// pick newest info.json by modtime (safety)
-	var newest string
-	var newestMod time.Time
-	for _, f := range infoFiles {
-		fi, e := os.Stat(f)
-		if e != nil {
-			continue
-		}
-		if fi.ModTime().After(newestMod) {
-			newestMod = fi.ModTime()
-			newest = f
-		}
+	if opts.EmbedThumbnail {
+		// yt-dlp deletes the thumbnail file once it is embedded
+		args = append(args, "--embed-thumbnail", "--convert-thumbnails", "jpg")
 	}
-	if newest == "" {
-		newest = infoFiles[0]
+	if opts.extractAudio() && len(opts.Formats) > 1 {
+		// keep the original stream so extra formats are converted from it
+		// rather than from the already lossy primary file
+		args = append(args, "--keep-video")
 	}
-
-	// parse ID from info json
-	raw, err := os.ReadFile(newest)
-	if err != nil {
-		return "", "", "", fmt.Errorf("read info json: %w", err)
+	if f := opts.ytdlpMatchFilter(); f != "" {
+		args = append(args, "--match-filter", f)
 	}
-	var parsed map[string]interface{}
-	if err := json.Unmarshal(raw, &parsed); err != nil {
-		return "", "", "", fmt.Errorf("parse info json: %w", err)
+	if opts.PlaylistItems != "" {
+		args = append(args, "--playlist-items", opts.PlaylistItems)
 	}
-	idVal, _ := parsed["id"].(string)
-	if idVal == "" {
-		idVal = strings.TrimSuffix(filepath.Base(newest), ".info.json")
+	if opts.DateAfter != "" {
+		args = append(args, "--dateafter", opts.DateAfter)
 	}
-
-	// tmp file paths
-	tmpInfo := newest
-	tmpMp3 := filepath.Join(tmpDir, idVal+".mp3")
-
-	// final destinations
-	finalInfo := filepath.Join(dataDir, idVal+".info.json")
-	finalMp3 := filepath.Join(mp3Dir, idVal+".mp3")
-
-	// ensure final directories exist (caller generally creates them, but double-check)
-	if err := os.MkdirAll(filepath.Dir(finalInfo), 0o755); err != nil {
-		return "", "", "", fmt.Errorf("mkdir dataDir: %w", err)
+	if opts.Archive != "" {
+		args = append(args, "--download-archive", opts.Archive)
+	}
+	if opts.CacheDir != "" || opts.KeepTemp {
+		// the job dir may hold .part files of an earlier attempt
+		args = append(args, "--continue")
 	}
-	if err := os.MkdirAll(filepath.Dir(finalMp3), 0o755); err != nil {
-		return "", "", "", fmt.Errorf("mkdir mp3Dir: %w", err)
+	if opts.DateBefore != "" {
+		args = append(args, "--datebefore", opts.DateBefore)
 	}
+	if opts.SponsorBlock != "" {
+		args = append(args, "--sponsorblock-remove", opts.SponsorBlock)
+	}
+	if opts.Section != "" {
+		args = append(args, "--download-sections", "*"+opts.Section)
+	}
+	switch opts.LivePolicy {
+	case livePolicyFromStart:
+		args = append(args, "--live-from-start")
+	case livePolicyWait:
+		// also covers scheduled streams that have not started yet
+		args = append(args, "--wait-for-video", "60")
+	}
+	// last, so they win over the options above where yt-dlp lets the
+	// later one win
+	args = append(args, opts.ExtraArgs...)
+	return append(args, url)
+}
 
-	// move files
-	if err := moveFile(tmpInfo, finalInfo); err != nil {
-		return "", "", "", fmt.Errorf("move info.json: %w", err)
+// networkArgs returns the yt-dlp arguments controlling how requests are made,
+// shared by downloads and metadata probes.
+func networkArgs(opts Options) []string {
+	var args []string
+	if opts.UserAgent != "" {
+		args = append(args, "--user-agent", opts.UserAgent)
+	}
+	for _, h := range opts.Headers {
+		args = append(args, "--add-header", h)
+	}
+	if opts.Proxy != "" {
+		args = append(args, "--proxy", opts.Proxy)
 	}
-	if _, err := os.Stat(tmpMp3); err == nil {
-		if err := moveFile(tmpMp3, finalMp3); err != nil {
-			return "", "", "", fmt.Errorf("move mp3: %w", err)
+	if opts.Cookies != "" {
+		args = append(args, "--cookies", opts.Cookies)
+	}
+	if opts.CookiesFromBrowser != "" {
+		args = append(args, "--cookies-from-browser", opts.CookiesFromBrowser)
+	}
+	return args
+}
+
+// commandLine renders the yt-dlp invocation for url as a shell command, for
+// storing alongside the track. The per-job temp dir is left out.
+func commandLine(opts Options, url string) string {
+	opts.Proxy = redactProxy(opts.Proxy)
+	args := append([]string{opts.YtDlp}, buildYtDlpArgs(opts, opts.NameTemplate+".%(ext)s", url)...)
+	for i, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t\n'\"\\$`*?[]()&;|<>#~!{}") {
+			args[i] = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
 		}
-	} else {
-		return idVal, finalInfo, "", errors.New("no mp3 file produced by yt-dlp")
 	}
+	return strings.Join(args, " ")
+}
 
-	// cleanup tmp dir
-	_ = os.RemoveAll(tmpDir)
+// stderrTailSize is how much of the end of yt-dlp's stderr is added to the
+// error when it fails, and so to error_text. yt-dlp prints the reason last,
+// and 4KB keeps a batch of failures from bloating the DB.
+const stderrTailSize = 4 << 10
 
-	return idVal, finalInfo, finalMp3, nil
+// toolStderr is where yt-dlp's stderr is shown: the terminal, or nowhere
+// with -quiet.
+func (o Options) toolStderr() io.Writer {
+	if o.Quiet {
+		return io.Discard
+	}
+	return os.Stderr
 }
 
-func parseInfoJSON(infoPath string) (YtdlpInfo, string, error) {
+// probeInfo fetches metadata for url without downloading anything.
+// Playlists are not expanded, so only the top-level entry is returned. Like
+// a download, it is killed after Options.Timeout.
+func probeInfo(opts Options, url string) (YtdlpInfo, error) {
 	var info YtdlpInfo
-	raw, err := os.ReadFile(infoPath)
-	if err != nil {
-		return info, "", err
+	ctx := context.Background()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
 	}
-	if err := json.Unmarshal(raw, &info); err != nil {
-		return info, "", err
+	args := append([]string{"--no-warnings", "--flat-playlist", "--dump-single-json"}, networkArgs(opts)...)
+	cmd := exec.CommandContext(ctx, opts.YtDlp, append(args, url)...)
+	cmd.WaitDelay = toolWaitDelay
+	tail := newStderrTail(stderrTailSize)
+	cmd.Stderr = io.MultiWriter(opts.toolStderr(), tail)
+	out, err := cmd.Output()
+	if err != nil && !errors.Is(err, exec.ErrWaitDelay) {
+		if ctx.Err() == context.DeadlineExceeded {
+			return info, fmt.Errorf("yt-dlp probe failed: %w", errDownloadTimeout)
+		}
+		if msg := tail.String(); msg != "" {
+			return info, fmt.Errorf("yt-dlp probe failed: %w: %s", err, msg)
+		}
+		return info, fmt.Errorf("yt-dlp probe failed: %w", err)
 	}
-	return info, string(raw), nil
+	if err := json.Unmarshal(out, &info); err != nil {
+		return info, fmt.Errorf("parse probe json: %w", err)
+	}
+	return info, nil
 }
 
-func upsertTrack(db *sql.DB, info YtdlpInfo, rawJson, url, mp3Path, status, errText string) error {
-	stmt := `INSERT INTO tracks (ytdlp_id, url, title, uploader, duration_seconds, mp3_path, info_json, status, error_text)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-	ON CONFLICT(ytdlp_id) DO UPDATE SET
-		url=excluded.url,
-		title=excluded.title,
-		uploader=excluded.uploader,
-		duration_seconds=excluded.duration_seconds,
-		mp3_path=excluded.mp3_path,
-		info_json=excluded.info_json,
-		status=excluded.status,
-		error_text=excluded.error_text;`
-	_, err := db.Exec(stmt, info.ID, url, info.Title, info.Uploader, int64(info.Duration), mp3Path, rawJson, status, errText)
-	return err
+// waitWhileLive blocks while url is a live stream, for -live-policy wait,
+// and returns its metadata once it has ended. The other policies need no
+// probe: skip leaves live streams to ytdlpMatchFilter.
+func waitWhileLive(workerID int, opts Options, url string) (info YtdlpInfo, err error) {
+	for {
+		if info, err = probeInfo(opts, url); err != nil || !info.IsLive {
+			return info, err
+		}
+		workerLog(workerID).withURL(url).Infof("%s is live, re-checking in %s", sanitizeForLog(url), liveWaitInterval)
+		time.Sleep(liveWaitInterval)
+	}
 }
 
-func worker(id int, db *sql.DB, mp3Dir, dataDir string, jobs <-chan Job, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for job := range jobs {
-		fmt.Printf("[worker %d] processing %s\n", id, job.URL)
+// sponsorBlockCategories are the segment categories yt-dlp can remove.
+// "all" stands for every one of them; a leading "-" excludes one again.
+var sponsorBlockCategories = map[string]bool{
+	"all": true, "sponsor": true, "intro": true, "outro": true, "selfpromo": true, "preview": true,
+	"filler": true, "interaction": true, "music_offtopic": true, "chapter": true,
+}
 
-		// quick skip: if DB already has this URL with successful status, skip
-		var exists int
-		err := db.QueryRow("SELECT 1 FROM tracks WHERE url = ? AND status = 'downloaded' LIMIT 1", job.URL).Scan(&exists)
-		if err == nil {
-			fmt.Printf("[worker %d] already downloaded (DB), skipping %s\n", id, job.URL)
-			continue
-		}
+// playlistItemRe matches one comma separated part of -playlist-items: an
+// index, or a range START-END or START:END[:STEP] with either end left out.
+// Indexes may be negative to count from the end, as in yt-dlp.
+var playlistItemRe = regexp.MustCompile(`^(?:-?\d+)?(?:[-:](?:-?\d+)?(?::-?\d+)?)?$`)
 
-		yid, infoPath, mp3Path, err := callYtDlp(mp3Dir, dataDir, job.URL)
-		if err != nil {
-			fmt.Printf("[worker %d] download failed: %v\n", id, err)
-			_ = upsertTrack(db, YtdlpInfo{ID: yid}, "", job.URL, "", "failed", err.Error())
-			continue
+// checkPlaylistItems validates a -playlist-items value like 1-10,15,20-.
+func checkPlaylistItems(v string) error {
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if !playlistItemRe.MatchString(part) || strings.IndexFunc(part, unicode.IsDigit) < 0 {
+			return fmt.Errorf("want indexes and ranges like 1-10,15,20-, got %q", part)
 		}
+	}
+	return nil
+}
 
-		info, raw, err := parseInfoJSON(infoPath)
-		if err != nil {
-			fmt.Printf("[worker %d] failed to parse info json: %v\n", id, err)
-			_ = upsertTrack(db, YtdlpInfo{ID: yid}, "", job.URL, mp3Path, "failed", "parse-info-json:"+err.Error())
-			continue
-		}
+// sectionTimeRe matches one end of a -section range: [HH:]MM:SS with an
+// optional fraction.
+var sectionTimeRe = regexp.MustCompile(`^(?:(\d+):)?(\d{1,2}):(\d{2})(?:\.\d+)?$`)
 
-		if info.ID == "" {
-			info.ID = yid
+// parseSection checks a -section value and returns it as START-END without
+// the "*" yt-dlp wants in front of time ranges, which may be given or not.
+func parseSection(v string) (string, error) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "*")
+	if v == "" {
+		return "", nil
+	}
+	start, end, ok := strings.Cut(v, "-")
+	if !ok {
+		return "", fmt.Errorf("no \"-\" in %q", v)
+	}
+	seconds := func(t string) (float64, error) {
+		m := sectionTimeRe.FindStringSubmatch(t)
+		if m == nil {
+			return 0, fmt.Errorf("bad time %q", t)
 		}
-		if err := upsertTrack(db, info, raw, job.URL, mp3Path, "downloaded", ""); err != nil {
-			fmt.Printf("[worker %d] db insert failed: %v\n", id, err)
-			continue
+		h, _ := strconv.Atoi(m[1])
+		min, _ := strconv.Atoi(m[2])
+		sec, _ := strconv.ParseFloat(t[strings.LastIndex(t, ":")+1:], 64)
+		if min >= 60 || sec >= 60 {
+			return 0, fmt.Errorf("bad time %q", t)
 		}
-		fmt.Printf("[worker %d] done: %s -> %s\n", id, job.URL, mp3Path)
+		return float64(h*3600+min*60) + sec, nil
 	}
-}
-
-func readCSVUrls(path string) ([]string, error) {
-	f, err := os.Open(path)
+	from, err := seconds(start)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	defer f.Close()
-	r := csv.NewReader(bufio.NewReader(f))
-	urls := []string{}
+	to, err := seconds(end)
+	if err != nil {
+		return "", err
+	}
+	if to <= from {
+		return "", fmt.Errorf("%s does not end after it starts", v)
+	}
+	return v, nil
+}
 
-	// optional header
-	first, err := r.Read()
-	if err == nil {
-		if len(first) > 0 && strings.Contains(strings.ToLower(first[0]), "url") {
-			// header detected -> skip
-		} else if len(first) > 0 {
-			urls = append(urls, strings.TrimSpace(first[0]))
-		}
-	} else if err == io.EOF {
-		return urls, nil
-	} else {
-		return nil, err
+// clipID is the ytdlp_id a -section clip of video id is stored under, so
+// several clips of one video and the whole video each get their own row.
+func clipID(id, section string) string {
+	if id == "" || section == "" {
+		return id
 	}
+	return id + "@" + section
+}
 
-	for {
-		rec, err := r.Read()
-		if err == io.EOF {
+// errFilteredOut is returned by callYtDlp when yt-dlp skipped the URL, or
+// every entry of a playlist, because of Options.MatchFilter or the upload
+// date range.
+var errFilteredOut = errors.New("does not pass filter")
+
+// errArchived is returned by callYtDlp when yt-dlp skipped the URL, or every
+// entry of a playlist, because it is already in the -archive file.
+var errArchived = errors.New("already in download archive")
+
+// archivedLine is what yt-dlp prints for a video it skips because of
+// --download-archive.
+const archivedLine = "has already been recorded in the archive"
+
+// errLive is returned by callYtDlp when -live-policy skip kept yt-dlp from
+// downloading the URL, or every entry of a playlist, as a live stream.
+var errLive = errors.New("live stream")
+
+// filteredLine is what yt-dlp prints for a video failing --match-filter.
+const filteredLine = "does not pass filter"
+
+// liveFilter is the --match-filter condition of -live-policy skip, which
+// saves probing every URL before its download.
+const liveFilter = "!is_live"
+
+// ytdlpMatchFilter is the --match-filter for yt-dlp: Options.MatchFilter,
+// and liveFilter with -live-policy skip.
+func (o Options) ytdlpMatchFilter() string {
+	if o.LivePolicy != livePolicySkip {
+		return o.MatchFilter
+	}
+	if o.MatchFilter == "" {
+		return liveFilter
+	}
+	return o.MatchFilter + " & " + liveFilter
+}
+
+// filters describes the filters yt-dlp applies to each video, for the
+// error_text of skipped-filter rows; "" when there are none. liveFilter
+// alone does not count, as what it skips is recorded as skipped-live.
+func (o Options) filters() string {
+	var parts []string
+	if o.MatchFilter != "" {
+		parts = append(parts, o.MatchFilter)
+	}
+	if o.DateAfter != "" || o.DateBefore != "" {
+		parts = append(parts, fmt.Sprintf("upload date %s-%s", o.DateAfter, o.DateBefore))
+	}
+	if len(parts) > 0 && o.LivePolicy == livePolicySkip {
+		// yt-dlp does not say which filter a video failed
+		parts = append(parts, liveFilter)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// parseUploadDate checks a -dateafter/-datebefore value, a date as
+// YYYYMMDD; "" is no limit.
+func parseUploadDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse("20060102", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("want a date as YYYYMMDD, got %q", s)
+	}
+	return t, nil
+}
+
+// matchFilter joins the -match-filter expressions and the conditions of
+// -min-duration and -max-duration into one --match-filter, which a video
+// must pass all of. Videos without a duration fail the duration conditions,
+// which also keeps out running live streams.
+func matchFilter(filters []string, min, max time.Duration) string {
+	var conds []string
+	for _, f := range filters {
+		if f = strings.TrimSpace(f); f != "" {
+			conds = append(conds, f)
+		}
+	}
+	if min > 0 {
+		conds = append(conds, fmt.Sprintf("duration >= %d", int64(min.Seconds())))
+	}
+	if max > 0 {
+		conds = append(conds, fmt.Sprintf("duration < %d", int64(max.Seconds())))
+	}
+	return strings.Join(conds, " & ")
+}
+
+// errDownloadTimeout is returned by callYtDlp when yt-dlp ran longer than
+// Options.Timeout and was killed. Its text is what ends up in error_text.
+var errDownloadTimeout = errors.New("timeout")
+
+// toolWaitDelay is how long callYtDlp waits for yt-dlp's output pipes to
+// close once it has exited or been killed. Children it left running would
+// otherwise keep a job going past -timeout. yt-dlp stays in our process
+// group, so Ctrl-C in the terminal still reaches it and its ffmpeg.
+const toolWaitDelay = 5 * time.Second
+
+// callYtDlp downloads audio only into a per-job temporary directory, then moves files to opts.Mp3Dir and opts.DataDir.
+// Any formats beyond the first are converted locally with ffmpeg from the same download.
+// A playlist URL yields one Download per entry, in playlist order; entries
+// that could not be finished carry their problem in Download.Err.
+func callYtDlp(opts Options, url string) (_ []Download, err error) {
+	tmpDir, err := jobDir(opts, url)
+	if err != nil {
+		return nil, fmt.Errorf("create job dir: %w", err)
+	}
+	// ensure we cleanup temp dir if anything goes wrong; on success files will be moved out
+	defer func() {
+		// with -keep-temp a failed download leaves its partial files for
+		// the next attempt
+		if err != nil && opts.KeepTemp && !errors.Is(err, errFilteredOut) && !errors.Is(err, errArchived) && !errors.Is(err, errLive) {
+			return
+		}
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	outTpl := filepath.Join(tmpDir, opts.NameTemplate+".%(ext)s")
+	args := buildYtDlpArgs(opts, outTpl, url)
+
+	ctx := context.Background()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, opts.YtDlp, args...)
+	cmd.WaitDelay = toolWaitDelay
+	// stderr is kept in any case, so a failed row says why it failed
+	tail := newStderrTail(stderrTailSize)
+	// yt-dlp reports videos it skips for the archive on stdout
+	outTail := newStderrTail(stderrTailSize)
+	var stdout io.Writer = toolStdout()
+	if opts.Quiet {
+		stdout = io.Discard
+	}
+	cmd.Stdout = io.MultiWriter(stdout, outTail)
+	cmd.Stderr = io.MultiWriter(opts.toolStderr(), tail)
+	// ErrWaitDelay alone means yt-dlp exited fine but left a child behind
+	if err := cmd.Run(); err != nil && !errors.Is(err, exec.ErrWaitDelay) {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errDownloadTimeout
+		}
+		if msg := tail.String(); msg != "" {
+			return nil, fmt.Errorf("yt-dlp failed: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("yt-dlp failed: %w", err)
+	}
+
+	// find .info.json in tmpDir
+	infoFiles, err := filepath.Glob(filepath.Join(tmpDir, "*.info.json"))
+	if err != nil || len(infoFiles) == 0 {
+		// fallback recursive scan
+		_ = filepath.WalkDir(tmpDir, func(p string, d fs.DirEntry, e error) error {
+			if e != nil {
+				return nil
+			}
+			if strings.HasSuffix(p, ".info.json") {
+				infoFiles = append(infoFiles, p)
+			}
+			return nil
+		})
+	}
+	archived := opts.Archive != "" && strings.Contains(outTail.String(), archivedLine)
+	live := opts.LivePolicy == livePolicySkip && strings.Contains(outTail.String(), filteredLine)
+	if len(infoFiles) == 0 {
+		if archived {
+			return nil, errArchived
+		}
+		if f := opts.filters(); f != "" {
+			// a filtered video exits 0 without writing anything
+			return nil, fmt.Errorf("%w: %s", errFilteredOut, f)
+		}
+		if live {
+			return nil, errLive
+		}
+		return nil, errors.New("no .info.json produced by yt-dlp")
+	}
+
+	// yt-dlp writes entries one after another, so modtime order is
+	// playlist order
+	modTime := func(p string) time.Time {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return time.Time{}
+		}
+		return fi.ModTime()
+	}
+	sort.SliceStable(infoFiles, func(i, j int) bool {
+		return modTime(infoFiles[i]).Before(modTime(infoFiles[j]))
+	})
+
+	var downloads []Download
+	for _, infoFile := range infoFiles {
+		// parse ID from info json
+		// files are named by -name-template, so the id comes from the JSON
+		stem := strings.TrimSuffix(filepath.Base(infoFile), ".info.json")
+		idVal := stem
+		raw, err := os.ReadFile(infoFile)
+		if err != nil {
+			downloads = append(downloads, Download{ID: idVal, Err: fmt.Errorf("read info json: %w", err)})
+			continue
+		}
+		var parsed map[string]interface{}
+		if err := decodeInfoJSON(raw, &parsed); err != nil {
+			downloads = append(downloads, Download{ID: idVal, Err: fmt.Errorf("parse info json: %w", err)})
+			continue
+		}
+		if parsed["_type"] == "playlist" {
+			// the playlist's own metadata; its entries have their own files
+			continue
+		}
+		if id, _ := parsed["id"].(string); id != "" {
+			idVal = id
+		}
+		entryOpts := opts
+		if opts.ByUploader {
+			uploader, _ := parsed["uploader"].(string)
+			entryOpts.Mp3Dir = filepath.Join(opts.Mp3Dir, uploaderDir(uploader))
+		}
+		dl, err := finishEntry(entryOpts, tmpDir, infoFile, stem, idVal)
+		dl.Err = err
+		dl.Webpage, _ = parsed["webpage_url"].(string)
+		downloads = append(downloads, dl)
+	}
+	if len(downloads) == 0 {
+		if archived {
+			return nil, errArchived
+		}
+		if f := opts.filters(); f != "" {
+			return nil, fmt.Errorf("%w: %s", errFilteredOut, f)
+		}
+		if live {
+			return nil, errLive
+		}
+		return nil, errors.New("playlist has no downloaded entries")
+	}
+	return downloads, nil
+}
+
+// jobDir returns the directory callYtDlp has yt-dlp download url into. It is
+// a new temp dir per job, to avoid races and cross-filesystem issues. With
+// -cache-dir or -keep-temp it is named after the URL and the options that
+// shape the files instead, so a restarted download finds its partial files
+// again and yt-dlp continues them.
+func jobDir(opts Options, url string) (string, error) {
+	if opts.CacheDir == "" && !opts.KeepTemp {
+		return os.MkdirTemp("", "ytjob-*")
+	}
+	base := opts.CacheDir
+	if base == "" {
+		base = os.TempDir()
+	}
+	key := strings.Join([]string{url, opts.Section, opts.NameTemplate, strings.Join(opts.Formats, ","), opts.FormatID, opts.FormatSort, strconv.FormatBool(opts.Video)}, "\x00")
+	sum := sha256.Sum256([]byte(key))
+	dir := filepath.Join(base, "ytjob-"+hex.EncodeToString(sum[:8]))
+	return dir, mkdirAll(dir, opts.DirMode)
+}
+
+// finishEntry moves the files of one downloaded video out of tmpDir,
+// converting extra formats on the way. stem is the file name yt-dlp
+// produced from -name-template, without extension; the final files keep it.
+func finishEntry(opts Options, tmpDir, tmpInfo, stem, idVal string) (Download, error) {
+	// tmp file paths
+	primary := opts.Formats[0]
+	var (
+		tmpMp3  string
+		missing error // reported once the info.json is in place
+	)
+	if opts.extractAudio() {
+		tmpMp3, missing = findAudio(tmpDir, stem, primary)
+	} else {
+		// the stream is kept as downloaded, so its extension is only known now
+		primary = "media"
+		if tmpMp3 = keptSource(tmpDir, stem, ""); tmpMp3 != "" {
+			primary = strings.TrimPrefix(filepath.Ext(tmpMp3), ".")
+		}
+	}
+
+	// final destinations
+	finalInfo := filepath.Join(opts.DataDir, stem+".info.json")
+	finalMp3 := filepath.Join(opts.Mp3Dir, stem+"."+primary)
+
+	// ensure final directories exist (caller generally creates them, but double-check)
+	if err := mkdirAll(filepath.Dir(finalInfo), opts.DirMode); err != nil {
+		return Download{ID: idVal}, fmt.Errorf("mkdir dataDir: %w", err)
+	}
+	if err := mkdirAll(filepath.Dir(finalMp3), opts.DirMode); err != nil {
+		return Download{ID: idVal}, fmt.Errorf("mkdir mp3Dir: %w", err)
+	}
+
+	// move files
+	if err := moveFile(tmpInfo, finalInfo); err != nil {
+		return Download{ID: idVal}, fmt.Errorf("move info.json: %w", err)
+	}
+	if missing != nil {
+		return Download{ID: idVal, InfoPath: finalInfo}, missing
+	}
+	if _, err := os.Stat(tmpMp3); err != nil {
+		return Download{ID: idVal, InfoPath: finalInfo}, fmt.Errorf("no %s file produced by yt-dlp", primary)
+	}
+	if opts.StrictAudio {
+		if err := sniffAudio(tmpMp3); err != nil {
+			return Download{ID: idVal, InfoPath: finalInfo}, fmt.Errorf("strict-audio: %w", err)
+		}
+	}
+
+	// extra formats are converted before the primary file is moved, since it
+	// may be the only source left when yt-dlp did not need to re-encode
+	extra := make(map[string]string)
+	if len(opts.Formats) > 1 {
+		src := keptSource(tmpDir, stem, primary)
+		if src == "" {
+			src = tmpMp3
+		}
+		for _, format := range opts.Formats[1:] {
+			tmpOut := filepath.Join(tmpDir, stem+".converted."+format)
+			if err := convertAudio(src, tmpOut, format); err != nil {
+				return Download{ID: idVal, InfoPath: finalInfo}, err
+			}
+			if !opts.NoMtime {
+				// match the primary file, which yt-dlp dated to the upload
+				if fi, err := os.Stat(tmpMp3); err == nil {
+					_ = os.Chtimes(tmpOut, fi.ModTime(), fi.ModTime())
+				}
+			}
+			finalOut := filepath.Join(opts.Mp3Dir, stem+"."+format)
+			if err := moveFile(tmpOut, finalOut); err != nil {
+				return Download{ID: idVal, InfoPath: finalInfo}, fmt.Errorf("move %s: %w", format, err)
+			}
+			extra[format] = finalOut
+		}
+	}
+
+	if err := moveFile(tmpMp3, finalMp3); err != nil {
+		return Download{ID: idVal, InfoPath: finalInfo}, fmt.Errorf("move %s: %w", primary, err)
+	}
+	return Download{ID: idVal, InfoPath: finalInfo, Mp3Path: finalMp3, Extra: extra}, nil
+}
+
+// findAudio returns the audio file yt-dlp extracted into tmpDir: stem.ext
+// when it exists, else the newest *.ext file, as yt-dlp may name the file
+// differently from the info.json (e.g. after sanitizing). The error lists
+// the temp dir when there is no .ext file at all.
+func findAudio(tmpDir, stem, ext string) (string, error) {
+	want := filepath.Join(tmpDir, stem+"."+ext)
+	if _, err := os.Stat(want); err == nil {
+		return want, nil
+	}
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		return "", err
+	}
+	var (
+		newest   string
+		newestAt time.Time
+		names    []string
+	)
+	for _, e := range entries {
+		names = append(names, e.Name())
+		if e.IsDir() || !strings.EqualFold(filepath.Ext(e.Name()), "."+ext) || strings.Contains(e.Name(), ".converted.") {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if newest == "" || fi.ModTime().After(newestAt) {
+			newest, newestAt = filepath.Join(tmpDir, e.Name()), fi.ModTime()
+		}
+	}
+	if newest == "" {
+		return "", fmt.Errorf("no %s file produced by yt-dlp (temp dir has: %s)", ext, strings.Join(names, ", "))
+	}
+	return newest, nil
+}
+
+// thumbnailExts are the image files -embed-thumbnail can leave in the temp
+// dir when embedding fails; they are never the media file.
+var thumbnailExts = map[string]bool{"jpg": true, "jpeg": true, "png": true, "webp": true}
+
+// keptSource returns the original stream yt-dlp left behind with --keep-video,
+// or "" when there is none.
+func keptSource(tmpDir, stem, primary string) string {
+	// titles can hold glob metacharacters, so no filepath.Glob here
+	entries, _ := os.ReadDir(tmpDir)
+	for _, e := range entries {
+		name := e.Name()
+		rest, ok := strings.CutPrefix(name, stem+".")
+		if !ok || e.IsDir() {
+			continue
+		}
+		ext := strings.TrimPrefix(filepath.Ext(name), ".")
+		if ext == primary || ext == "json" || ext == "part" || thumbnailExts[ext] || strings.HasPrefix(rest, "converted.") {
+			continue
+		}
+		return filepath.Join(tmpDir, name)
+	}
+	return ""
+}
+
+// errTruncatedInfoJSON marks an info.json that ends early, usually because
+// yt-dlp was killed while writing it. Downloading again fixes it.
+var errTruncatedInfoJSON = errors.New("info.json looks truncated")
+
+// decodeInfoJSON unmarshals an info.json blob, reporting errTruncatedInfoJSON
+// when a parse failure comes from the document not being closed.
+func decodeInfoJSON(raw []byte, v any) error {
+	err := json.Unmarshal(raw, v)
+	if err == nil {
+		return nil
+	}
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 || trimmed[len(trimmed)-1] != '}' {
+		return fmt.Errorf("%w (%d bytes): %v", errTruncatedInfoJSON, len(raw), err)
+	}
+	return err
+}
+
+func parseInfoJSON(infoPath string) (YtdlpInfo, string, error) {
+	var info YtdlpInfo
+	raw, err := os.ReadFile(infoPath)
+	if err != nil {
+		return info, "", err
+	}
+	if err := decodeInfoJSON(raw, &info); err != nil {
+		return info, "", err
+	}
+	return info, string(raw), nil
+}
+
+// Conflict policies for -conflict-policy, deciding what upsertTrack does when
+// a row for the same ytdlp_id already exists.
+const (
+	// conflictOverwrite replaces every column with the new values.
+	conflictOverwrite = "overwrite"
+	// conflictSkip leaves rows that are already downloaded untouched.
+	conflictSkip = "skip"
+	// conflictKeepMetadata refreshes status and paths but keeps existing
+	// title/uploader/duration/info_json, only filling them in when empty.
+	conflictKeepMetadata = "keep-metadata"
+)
+
+func validConflictPolicy(policy string) bool {
+	switch policy {
+	case conflictOverwrite, conflictSkip, conflictKeepMetadata:
+		return true
+	}
+	return false
+}
+
+// Track is one row of the tracks table as written by upsertTrack.
+type Track struct {
+	Info         YtdlpInfo
+	RawJSON      string
+	URL          string
+	Mp3Path      string
+	Status       Status
+	ErrText      string
+	LUFS         *float64 // nil when loudness was not measured
+	UserAgent    string   // user agent sent to the site, "" for yt-dlp's default
+	Command      string   // yt-dlp command line used for the download
+	FormatID     string   // format_id requested with -format-id, "" for the default
+	Proxy        string   // proxy the download went through, without credentials
+	AudioQuality string   // --audio-quality the file was extracted with
+	SHA256       string   // hex digest of the primary file, "" when not hashed
+	DuplicateOf  *int64   // id of the row with the same content, for status duplicate
+	Notes        string   // free text from the input, "" keeps the stored notes
+	SponsorBlock string   // SponsorBlock categories cut from the file, "" for none
+	Section      string   // time range of a -section clip, "" for the whole video
+	DownloadMS   int64    // wall time of the yt-dlp run, 0 when it did not run
+}
+
+// trackColumn is one tracks column written by upsertTrack.
+type trackColumn struct {
+	name  string
+	value any
+	// metadata columns are left alone under conflictKeepMetadata unless the
+	// stored value is empty (equal to the SQL literal in empty)
+	metadata bool
+	empty    string
+	// sticky columns keep the stored value when the new one is empty
+	sticky bool
+}
+
+func upsertTrack(db *sql.DB, policy string, t Track) error {
+	info := t.Info
+	cols := []trackColumn{
+		{name: "url", value: t.URL},
+		{name: "title", value: info.Title, metadata: true, empty: "''"},
+		{name: "uploader", value: info.Uploader, metadata: true, empty: "''"},
+		{name: "duration_seconds", value: int64(info.Duration), metadata: true, empty: "0"},
+		{name: "mp3_path", value: t.Mp3Path},
+		{name: "info_json", value: t.RawJSON, metadata: true, empty: "''"},
+		{name: "status", value: t.Status},
+		{name: "error_text", value: t.ErrText},
+		{name: "lufs", value: t.LUFS, metadata: true, empty: "NULL"},
+		{name: "user_agent", value: t.UserAgent},
+		{name: "command", value: t.Command},
+		{name: "format_id", value: t.FormatID},
+		{name: "proxy", value: t.Proxy},
+		{name: "audio_quality", value: t.AudioQuality},
+		{name: "webpage_url", value: info.Webpage, metadata: true, empty: "''"},
+		{name: "upload_date", value: info.UploadDate, metadata: true, empty: "''"},
+		{name: "view_count", value: info.ViewCount, metadata: true, empty: "0"},
+		{name: "like_count", value: info.LikeCount, metadata: true, empty: "0"},
+		{name: "sha256", value: t.SHA256},
+		{name: "duplicate_of", value: t.DuplicateOf},
+		{name: "notes", value: t.Notes, sticky: true},
+		{name: "sponsorblock", value: t.SponsorBlock},
+		{name: "section", value: t.Section},
+		{name: "download_ms", value: sql.NullInt64{Int64: t.DownloadMS, Valid: t.DownloadMS > 0}},
+	}
+
+	names := []string{"ytdlp_id"}
+	values := []any{info.ID}
+	var sets []string
+	for _, c := range cols {
+		names = append(names, c.name)
+		values = append(values, c.value)
+		if c.metadata && policy == conflictKeepMetadata {
+			sets = append(sets, fmt.Sprintf("%[1]s=COALESCE(NULLIF(tracks.%[1]s, %[2]s), excluded.%[1]s)", c.name, c.empty))
+		} else if c.sticky {
+			sets = append(sets, fmt.Sprintf("%[1]s=COALESCE(NULLIF(excluded.%[1]s, ''), tracks.%[1]s)", c.name))
+		} else {
+			sets = append(sets, fmt.Sprintf("%[1]s=excluded.%[1]s", c.name))
+		}
+	}
+	insert := fmt.Sprintf("INSERT INTO tracks (%s) VALUES (?%s)",
+		strings.Join(names, ", "), strings.Repeat(", ?", len(names)-1))
+
+	if info.ID == "" {
+		// tracks that never got a yt-dlp id (failed or skipped before
+		// downloading) would all collide on ytdlp_id, so they are stored
+		// with a NULL id and replaced by url instead; such rows are never
+		// downloaded, so there is nothing for the policies to protect
+		values[0] = nil
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if err := keepSticky(tx, cols, values[1:], t.URL); err != nil {
+			return err
+		}
+		if err := deleteIDlessRows(tx, t.URL); err != nil {
+			return err
+		}
+		res, err := tx.Exec(insert, values...)
+		if err != nil {
+			return err
+		}
+		if t.RawJSON != "" {
+			trackID, err := res.LastInsertId()
+			if err != nil {
+				return err
+			}
+			if err := replaceTags(tx, trackID, info.Tags); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	}
+
+	stmt := insert + "\n\tON CONFLICT(ytdlp_id) DO UPDATE SET " + strings.Join(sets, ", ")
+	if policy == conflictSkip {
+		stmt += " WHERE tracks.status <> '" + string(statusDownloaded) + "'"
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := keepSticky(tx, cols, values[1:], t.URL); err != nil {
+		return err
+	}
+	res, err := tx.Exec(stmt, values...)
+	if err != nil {
+		return err
+	}
+	// tags are replaced along with the rest of the metadata: not when the
+	// policy left the row alone, and under conflictKeepMetadata only if the
+	// track has none yet
+	if n, _ := res.RowsAffected(); n > 0 && t.RawJSON != "" {
+		var trackID int64
+		var stored int
+		err := tx.QueryRow("SELECT id, (SELECT COUNT(*) FROM tags WHERE track_id = tracks.id) FROM tracks WHERE ytdlp_id = ?", info.ID).Scan(&trackID, &stored)
+		if err != nil {
+			return err
+		}
+		if policy != conflictKeepMetadata || stored == 0 {
+			if err := replaceTags(tx, trackID, info.Tags); err != nil {
+				return err
+			}
+		}
+	}
+	// an earlier attempt at the url that failed before getting an id is
+	// superseded by this row
+	if err := deleteIDlessRows(tx, t.URL); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteIDlessRows deletes the rows of url without a ytdlp_id, and their tags.
+func deleteIDlessRows(tx *sql.Tx, url string) error {
+	const idless = "SELECT id FROM tracks WHERE url = ? AND COALESCE(ytdlp_id, '') = ''"
+	if _, err := tx.Exec("DELETE FROM tags WHERE track_id IN ("+idless+")", url); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM tracks WHERE id IN ("+idless+")", url)
+	return err
+}
+
+// replaceTags sets the tags of a track, dropping the ones it had.
+func replaceTags(tx *sql.Tx, trackID int64, tags []string) error {
+	if _, err := tx.Exec("DELETE FROM tags WHERE track_id = ?", trackID); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag == "" {
+			continue
+		}
+		if _, err := tx.Exec("INSERT OR IGNORE INTO tags (track_id, tag) VALUES (?, ?)", trackID, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keepSticky fills the empty sticky values with those of the url's row
+// without an id, which the upsert is about to replace.
+func keepSticky(tx *sql.Tx, cols []trackColumn, values []any, url string) error {
+	for i, c := range cols {
+		if !c.sticky || c.value != "" {
+			continue
+		}
+		var old string
+		err := tx.QueryRow("SELECT "+c.name+" FROM tracks WHERE url = ? AND COALESCE(ytdlp_id, '') = '' AND COALESCE("+c.name+", '') <> ''", url).Scan(&old)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		values[i] = old
+	}
+	return nil
+}
+
+// getMeta reads a value from the meta key/value table; missing keys yield "".
+func getMeta(db *sql.DB, key string) (string, error) {
+	var value string
+	err := db.QueryRow("SELECT value FROM meta WHERE key = ?", key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return value, err
+}
+
+func setMeta(db *sql.DB, key, value string) error {
+	_, err := db.Exec("INSERT OR REPLACE INTO meta (key, value) VALUES (?, ?)", key, value)
+	return err
+}
+
+// hashFile streams path through SHA-256, returning the hex digest and the
+// number of bytes read.
+func hashFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", n, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// verifyDownload reads every file of dl back in full, failing on empty or
+// unreadable files.
+func verifyDownload(dl Download) error {
+	paths := []string{dl.Mp3Path}
+	for _, p := range dl.Extra {
+		paths = append(paths, p)
+	}
+	for _, p := range paths {
+		_, n, err := hashFile(p)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", filepath.Base(p), err)
+		}
+		if n == 0 {
+			return fmt.Errorf("%s is empty", filepath.Base(p))
+		}
+	}
+	return nil
+}
+
+// removeDownload deletes every file callYtDlp produced for dl.
+func removeDownload(dl Download) {
+	paths := []string{dl.Mp3Path, dl.InfoPath}
+	for _, p := range dl.Extra {
+		paths = append(paths, p)
+	}
+	for _, p := range paths {
+		if p != "" {
+			_ = os.Remove(p)
+		}
+	}
+}
+
+// replicate copies the audio files of dl into every directory in dests. It
+// returns the copies made, as path -> format, and an error naming each
+// destination that did not get a full copy.
+func replicate(dl Download, dests []string, dirMode os.FileMode) (map[string]string, error) {
+	files := map[string]string{dl.Mp3Path: strings.TrimPrefix(filepath.Ext(dl.Mp3Path), ".")}
+	for format, p := range dl.Extra {
+		files[p] = format
+	}
+	copies := make(map[string]string)
+	var failed []string
+	for _, dir := range dests {
+		err := mkdirAll(dir, dirMode)
+		for src, format := range files {
+			if err != nil {
+				break
+			}
+			dst := filepath.Join(dir, filepath.Base(src))
+			if err = copyFile(src, dst); err == nil {
+				copies[dst] = format
+			}
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", dir, err))
+		}
+	}
+	if len(failed) > 0 {
+		return copies, errors.New(strings.Join(failed, "; "))
+	}
+	return copies, nil
+}
+
+// recordTrackFile stores an additional output file belonging to a track.
+func recordTrackFile(db *sql.DB, ytdlpID, format, path string) error {
+	_, err := db.Exec(`INSERT OR REPLACE INTO track_files (ytdlp_id, format, path) VALUES (?, ?, ?)`, ytdlpID, format, path)
+	return err
+}
+
+// recordAttempt stores one download attempt for url in track_attempts. err
+// is nil for a successful attempt. Times use the same UTC format as
+// downloaded_at.
+func recordAttempt(db *sql.DB, url, ytdlpID string, start time.Time, err error) error {
+	var errText sql.NullString
+	if err != nil {
+		errText = sql.NullString{String: err.Error(), Valid: true}
+	}
+	_, dbErr := db.Exec(`INSERT INTO track_attempts (url, ytdlp_id, attempted_at, duration_ms, error_text) VALUES (?, ?, ?, ?, ?)`,
+		url, ytdlpID, start.UTC().Format(time.DateTime), time.Since(start).Milliseconds(), errText)
+	return dbErr
+}
+
+// sanitizeForLog makes s safe to print to a terminal or log file. Newlines,
+// tabs and other control characters (including the ESC that starts ANSI
+// sequences) are escaped, as are bidi overrides that could reorder the line.
+// Values stored in the DB are never sanitized.
+func sanitizeForLog(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case unicode.IsControl(r) || unicode.Is(unicode.Bidi_Control, r):
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// alreadyDownloaded reports whether url has a downloaded (or duplicate) row.
+// With verifyFiles, a downloaded row only counts while its mp3_path still
+// exists, so deleted files are fetched again. A nil db has no rows.
+func alreadyDownloaded(db *sql.DB, url, section string, verifyFiles bool) (bool, error) {
+	if db == nil {
+		return false, nil
+	}
+	sectionCol, err := sectionColumn(db)
+	if err != nil {
+		return false, err
+	}
+	rows, err := db.Query("SELECT status, COALESCE(mp3_path, '') FROM tracks WHERE url = ? AND "+sectionCol+" = ? AND status IN (?, ?)", url, section, statusDownloaded, statusDuplicate)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	found := false
+	for rows.Next() {
+		var status Status
+		var path string
+		if err := rows.Scan(&status, &path); err != nil {
+			return false, err
+		}
+		if !verifyFiles {
+			return true, nil
+		}
+		// duplicates have no file of their own
+		if status == statusDownloaded {
+			if _, err := os.Stat(path); err != nil {
+				return false, rows.Err()
+			}
+		}
+		found = true
+	}
+	return found, rows.Err()
+}
+
+// sectionColumn returns what to compare -download-sections against: the
+// section column, or an empty string in a DB from before it was added, which
+// a dry run reads without migrating.
+func sectionColumn(db *sql.DB) (string, error) {
+	ok, err := hasColumn(db, "tracks", "section")
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "''", nil
+	}
+	return "COALESCE(section, '')", nil
+}
+
+// failedRecently reports whether url has a failed row whose last attempt
+// was less than retryAfter ago; with retryAfter 0, any failed row counts.
+// The last attempt is the newest of the row's downloaded_at, which keeps the
+// time of the first insert, and the url's track_attempts.
+func failedRecently(db *sql.DB, url, section string, retryAfter time.Duration) (bool, error) {
+	if db == nil {
+		return false, nil
+	}
+	sectionCol, err := sectionColumn(db)
+	if err != nil {
+		return false, err
+	}
+	attempted := "(SELECT MAX(attempted_at) FROM track_attempts WHERE track_attempts.url = tracks.url)"
+	if ok, err := hasTable(db, "track_attempts"); err != nil {
+		return false, err
+	} else if !ok {
+		attempted = "NULL"
+	}
+	var last string
+	err = db.QueryRow(`SELECT MAX(COALESCE(downloaded_at, ''), COALESCE(`+attempted+`, ''))
+		FROM tracks WHERE url = ? AND `+sectionCol+` = ? AND status = ?
+		ORDER BY 1 DESC LIMIT 1`, url, section, statusFailed).Scan(&last)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if retryAfter <= 0 {
+		return true, nil
+	}
+	// both columns are UTC in time.DateTime format, which sorts as text
+	return last > time.Now().UTC().Add(-retryAfter).Format(time.DateTime), nil
+}
+
+// uploaderDir turns an uploader name into a single directory name for
+// -by-uploader: path separators and characters Windows rejects become "_",
+// control characters are dropped, and names left empty become "unknown", so
+// the result can never leave the mp3 dir.
+func uploaderDir(uploader string) string {
+	var b strings.Builder
+	for _, r := range uploader {
+		switch {
+		case unicode.IsControl(r) || unicode.Is(unicode.Bidi_Control, r):
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			b.WriteRune('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	// leading dots would hide the directory, and Windows drops trailing
+	// dots and spaces
+	name := strings.Trim(b.String(), ". ")
+	if runes := []rune(name); len(runes) > 100 {
+		name = strings.TrimRight(string(runes[:100]), ". ")
+	}
+	if name == "" {
+		return "unknown"
+	}
+	return name
+}
+
+func worker(id int, db *sql.DB, opts Options, pool *workerPool, w *dbWriter, cp *checkpoint) {
+	failed := false // whether the current job saved a failed row
+	saved := false  // whether the previous job saved anything, so reached the site
+	var job Job
+	save := func(t Track) error {
+		failed = failed || t.Status == statusFailed
+		saved = true
+		t.Notes = job.Notes
+		// duplicates are deleted again, so they do not count
+		if t.Status == statusDownloaded || t.Status == statusPartialReplication {
+			pool.downloaded()
+		}
+		opts.Stats.record(id, t)
+		return w.do(func(db *sql.DB) error { return upsertTrack(db, opts.ConflictPolicy, t) })
+	}
+	for {
+		var ok bool
+		if job, ok = pool.next(); !ok {
+			return
+		}
+		if saved {
+			time.Sleep(opts.sleepBetweenJobs())
+		}
+		failed, saved = false, false
+		processJob(id, db, opts, job, save, w)
+		bar.finish(failed)
+		cp.done(job.Line)
+	}
+}
+
+// processJob downloads and records a single job. Every outcome, including
+// skips and failures, is saved through save.
+func processJob(id int, db *sql.DB, opts Options, job Job, save func(Track) error, w *dbWriter) {
+	// URLs, titles and errors can carry control characters from the
+	// input or the remote site, so only sanitized copies are printed
+	safeURL := sanitizeForLog(job.URL)
+	log := workerLog(id).withURL(job.URL)
+	log.Infof("processing %s", safeURL)
+
+	// quick skip: if DB already has this URL with successful status, skip;
+	// a retried playlist entry has downloaded siblings under its URL
+	var err error
+	if job.Entry == "" {
+		done, err := alreadyDownloaded(db, job.URL, opts.Section, opts.VerifyFiles)
+		if err != nil {
+			log.Warnf("db check failed: %v", err)
+		}
+		if done {
+			if !opts.Overwrite {
+				log.Infof("already downloaded (DB), skipping %s", safeURL)
+				opts.Stats.skipped(id)
+				return
+			}
+			log.Infof("already downloaded (DB), overwriting %s", safeURL)
+		}
+	}
+	if opts.SkipFailed {
+		failed, err := failedRecently(db, job.URL, opts.Section, opts.RetryAfter)
+		if err != nil {
+			log.Warnf("db check failed: %v", err)
+		}
+		if failed {
+			log.Infof("failed before (DB), skipping %s", safeURL)
+			opts.Stats.skipped(id)
+			return
+		}
+	}
+
+	// held for the probe, the download and its retries
+	release := opts.Hosts.acquire(job.URL)
+	defer release()
+
+	// one proxy per job, so the probe and the download look the same
+	if opts.Proxies != nil {
+		opts.Proxy = opts.Proxies.pick()
+	}
+
+	var probed YtdlpInfo
+	if opts.LivePolicy == livePolicyWait {
+		if probed, err = waitWhileLive(id, opts, job.URL); err != nil {
+			// let the download itself report the real problem
+			log.Warnf("live check failed, downloading anyway: %s", sanitizeForLog(err.Error()))
+		}
+	}
+	// the row stays keyed by the input URL even if the user narrows a
+	// playlist down to one entry
+	dlURL := job.page()
+	if opts.Prompt != nil {
+		if probed.ID == "" && err == nil {
+			// only -live-policy wait probes before this
+			probed, err = probeInfo(opts, job.URL)
+		}
+		if err == nil && opts.Prompt.resolve(probed, &dlURL, &opts) {
+			log.Infof("skipped by user: %s", safeURL)
+			_ = save(Track{Info: probed, URL: job.URL, Status: statusSkippedUser})
+			return
+		}
+	}
+	// when a probe already told us the uploader, filter before downloading
+	if probed.Uploader != "" {
+		if reason := opts.Uploaders.check(probed.Uploader); reason != "" {
+			log.Infof("skipping %s: %s", safeURL, sanitizeForLog(reason))
+			_ = save(Track{Info: probed, URL: job.URL, Status: statusSkippedUploader, ErrText: reason})
+			return
+		}
+	}
+
+	var (
+		downloads []Download
+		command   string
+		proxy     string
+		took      time.Duration
+	)
+	for attempt := 1; ; attempt++ {
+		command = commandLine(opts, dlURL)
+		proxy = redactProxy(opts.Proxy)
+		if opts.Verbose {
+			log.Infof("running %s", command)
+		}
+		start := time.Now()
+		downloads, err = callYtDlp(opts, dlURL)
+		took = time.Since(start)
+		opts.Proxies.report(opts.Proxy, err)
+		attemptID := ""
+		if len(downloads) == 1 {
+			attemptID = downloads[0].ID
+		}
+		if dbErr := w.do(func(db *sql.DB) error { return recordAttempt(db, job.URL, attemptID, start, err) }); dbErr != nil {
+			log.Warnf("cannot record attempt: %v", dbErr)
+		}
+		// only a failing yt-dlp run is worth repeating; a missing output
+		// file or broken info.json would come out the same again
+		var exitErr *exec.ExitError
+		if err == nil || attempt > opts.Retries || !errors.As(err, &exitErr) {
+			break
+		}
+		delay := retryBaseDelay << (attempt - 1)
+		log.Warnf("attempt %d failed, retrying in %s: %s", attempt, delay, sanitizeForLog(err.Error()))
+		time.Sleep(delay)
+		if opts.Proxies != nil {
+			opts.Proxy = opts.Proxies.pick()
+		}
+	}
+	// fields shared by every row this job writes
+	base := Track{URL: job.URL, UserAgent: opts.UserAgent, Command: command, FormatID: opts.FormatID, Proxy: proxy, SponsorBlock: opts.SponsorBlock, Section: opts.Section, DownloadMS: took.Milliseconds()}
+	// a retried entry that fails again updates its own row
+	base.Info.ID = job.EntryID
+	if opts.extractAudio() {
+		base.AudioQuality = opts.AudioQuality
+	}
+	if errors.Is(err, errFilteredOut) {
+		log.Infof("skipping %s: %s", safeURL, sanitizeForLog(err.Error()))
+		t := base
+		t.Status, t.ErrText = statusSkippedFilter, err.Error()
+		_ = save(t)
+		return
+	}
+	if errors.Is(err, errLive) {
+		log.Infof("live stream, skipping %s", safeURL)
+		t := base
+		t.Status = statusSkippedLive
+		_ = save(t)
+		return
+	}
+	if errors.Is(err, errArchived) {
+		log.Infof("%s is in -archive, skipping", safeURL)
+		t := base
+		t.Status = statusSkippedArchive
+		_ = save(t)
+		return
+	}
+	if err != nil {
+		log.Errorf("download failed: %s", sanitizeForLog(err.Error()))
+		t := base
+		t.Status, t.ErrText = statusFailed, err.Error()
+		_ = save(t)
+		return
+	}
+	for _, dl := range downloads {
+		finishDownload(db, opts, dl, base, save, w, log)
+	}
+}
+
+// finishDownload checks and records one downloaded video of a job. base
+// holds the fields shared by all rows of the job.
+func finishDownload(db *sql.DB, opts Options, dl Download, base Track, save func(Track) error, w *dbWriter, log runLog) {
+	safeURL := sanitizeForLog(base.URL)
+	yid, infoPath, mp3Path := clipID(dl.ID, opts.Section), dl.InfoPath, dl.Mp3Path
+	fail := func(mp3Path, errText string) {
+		t := base
+		t.Info, t.Mp3Path, t.Status, t.ErrText = YtdlpInfo{ID: yid, Webpage: dl.Webpage}, mp3Path, statusFailed, errText
+		_ = save(t)
+	}
+	if dl.Err != nil {
+		log.Errorf("download failed: %s", sanitizeForLog(dl.Err.Error()))
+		fail("", dl.Err.Error())
+		return
+	}
+	if opts.VerifyDownload {
+		if err := verifyDownload(dl); err != nil {
+			log.Errorf("verification failed: %s", sanitizeForLog(err.Error()))
+			fail(mp3Path, "verify:"+err.Error())
+			return
+		}
+	}
+
+	info, raw, err := parseInfoJSON(infoPath)
+	if errors.Is(err, errTruncatedInfoJSON) {
+		// drop the broken file so rescan does not trip over it; the
+		// failed status makes the next run download it again
+		log.Warnf("truncated info json, will re-download: %s", sanitizeForLog(err.Error()))
+		_ = os.Remove(infoPath)
+		fail(mp3Path, "truncated-info-json:"+err.Error())
+		return
+	}
+	if err != nil {
+		log.Errorf("failed to parse info json: %s", sanitizeForLog(err.Error()))
+		fail(mp3Path, "parse-info-json:"+err.Error())
+		return
+	}
+
+	if info.ID == "" {
+		info.ID = dl.ID
+	}
+	info.ID = clipID(info.ID, opts.Section)
+	track := base
+	track.Info, track.RawJSON, track.Mp3Path, track.Status = info, raw, mp3Path, statusDownloaded
+	if missing := missingFields(raw, opts.RequireFields); len(missing) > 0 {
+		log.Errorf("missing metadata: %s", strings.Join(missing, ", "))
+		if opts.DeleteIncomplete {
+			removeDownload(dl)
+			track.Mp3Path = ""
+		}
+		track.Status, track.ErrText = statusFailed, "missing-metadata:"+strings.Join(missing, ",")
+		_ = save(track)
+		return
+	}
+	if reason := opts.Uploaders.check(info.Uploader); reason != "" {
+		log.Infof("skipping %s: %s", safeURL, sanitizeForLog(reason))
+		removeDownload(dl)
+		track.Mp3Path, track.Status, track.ErrText = "", statusSkippedUploader, reason
+		_ = save(track)
+		return
+	}
+	if !opts.NoHash {
+		if sum, _, err := hashFile(mp3Path); errors.Is(err, fs.ErrNotExist) {
+			// nothing to hash, e.g. a kept stream yt-dlp removed
+		} else if err != nil {
+			log.Warnf("cannot hash %s: %s", sanitizeForLog(mp3Path), sanitizeForLog(err.Error()))
+		} else {
+			track.SHA256 = sum
+		}
+	}
+	if opts.DedupContent && track.SHA256 != "" {
+		var canonical int64
+		err := db.QueryRow("SELECT id FROM tracks WHERE sha256 = ? AND status = ? AND COALESCE(ytdlp_id, '') <> ? ORDER BY id LIMIT 1", track.SHA256, statusDownloaded, info.ID).Scan(&canonical)
+		if err == nil {
+			log.Infof("duplicate of track %d, removing files: %s", canonical, safeURL)
+			// the info.json stays, it describes this URL's video
+			removeDownload(Download{Mp3Path: dl.Mp3Path, Extra: dl.Extra})
+			track.Mp3Path, track.Status, track.DuplicateOf = "", statusDuplicate, &canonical
+			_ = save(track)
+			return
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Warnf("duplicate check failed: %v", err)
+		}
+	}
+	if opts.MeasureLoudness {
+		lufs, err := measureLoudness(mp3Path)
+		if err != nil {
+			log.Warnf("loudness measurement failed: %s", sanitizeForLog(err.Error()))
+		} else {
+			track.LUFS = &lufs
+		}
+	}
+	var replicas map[string]string
+	if len(opts.Dests) > 0 {
+		var err error
+		if replicas, err = replicate(dl, opts.Dests, opts.DirMode); err != nil {
+			// the primary copy is fine, so keep the track but flag it
+			log.Errorf("replication failed: %s", sanitizeForLog(err.Error()))
+			track.Status = statusPartialReplication
+			track.ErrText = "replicate:" + err.Error()
+		}
+	}
+	// a re-download under another name (e.g. a new -name-template) would
+	// leave the old file behind
+	var oldPath string
+	if opts.Overwrite {
+		_ = db.QueryRow("SELECT COALESCE(mp3_path, '') FROM tracks WHERE ytdlp_id = ?", info.ID).Scan(&oldPath)
+	}
+	if err := save(track); err != nil {
+		log.Errorf("db insert failed: %v", err)
+		return
+	}
+	if oldPath != "" && oldPath != mp3Path {
+		if err := os.Remove(oldPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Warnf("cannot remove replaced file: %v", err)
+		}
+	}
+	files := make(map[string]string, len(dl.Extra)+len(replicas)) // path -> format
+	for format, path := range dl.Extra {
+		files[path] = format
+	}
+	for path, format := range replicas {
+		files[path] = format
+	}
+	for path, format := range files {
+		err := w.do(func(db *sql.DB) error { return recordTrackFile(db, info.ID, format, path) })
+		if err != nil {
+			log.Errorf("db insert failed for %s: %v", path, err)
+		}
+	}
+	if opts.WriteResult {
+		if err := writeResult(dl, track, replicas); err != nil {
+			log.Warnf("cannot write result file: %v", err)
+		}
+	}
+	log.Infof("done: %s (%s) -> %s", safeURL, sanitizeForLog(info.Title), mp3Path)
+}
+
+// csvRow is one input row read by readCSVUrls.
+type csvRow struct {
+	URL            string
+	Priority       int
+	Line           int
+	Notes          string
+	Entry, EntryID string // see Job
+}
+
+// readCSVUrls reads URLs from column urlCol of the CSV at path, or from
+// stdin when path is "-". Records too short to have that column are
+// skipped. When priorityCol is >= 0, that column holds an integer priority
+// for the row; missing or non-numeric values count as 0. notesCol works the
+// same way for free text notes.
+func readCSVUrls(path string, urlCol, priorityCol, notesCol int) ([]csvRow, error) {
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+	}
+	r := csv.NewReader(bufio.NewReader(in))
+	// spreadsheet exports often drop trailing empty cells
+	r.FieldsPerRecord = -1
+	rows := []csvRow{}
+
+	toRow := func(rec []string) (csvRow, bool) {
+		if urlCol >= len(rec) {
+			return csvRow{}, false
+		}
+		line, _ := r.FieldPos(urlCol)
+		row := csvRow{URL: strings.TrimSpace(rec[urlCol]), Line: line}
+		if row.URL == "" {
+			return row, false
+		}
+		if priorityCol >= 0 && priorityCol < len(rec) {
+			row.Priority, _ = strconv.Atoi(strings.TrimSpace(rec[priorityCol]))
+		}
+		if notesCol >= 0 && notesCol < len(rec) {
+			row.Notes = strings.TrimSpace(rec[notesCol])
+		}
+		return row, true
+	}
+
+	// optional header
+	first, err := r.Read()
+	if err == nil {
+		if urlCol < len(first) && strings.Contains(strings.ToLower(first[urlCol]), "url") {
+			// header detected -> skip
+		} else if row, ok := toRow(first); ok {
+			rows = append(rows, row)
+		}
+	} else if err == io.EOF {
+		return rows, nil
+	} else {
+		return nil, err
+	}
+
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
 			break
 		}
 		if err != nil {
 			return nil, err
 		}
-		if len(rec) == 0 {
+		if row, ok := toRow(rec); ok {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+// readTxtUrls reads one URL per line from path, or from stdin when path is
+// "-". Lines are trimmed; blank lines and lines starting with # are skipped.
+// There is no header detection, and commas are part of the URL.
+func readTxtUrls(path string) ([]csvRow, error) {
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+	}
+	var rows []csvRow
+	sc := bufio.NewScanner(in)
+	line := 0
+	for sc.Scan() {
+		line++
+		u := strings.TrimSpace(sc.Text())
+		if u == "" || strings.HasPrefix(u, "#") {
 			continue
 		}
-		url := strings.TrimSpace(rec[0])
-		if url == "" {
+		rows = append(rows, csvRow{URL: u, Line: line})
+	}
+	return rows, sc.Err()
+}
+
+// failedURLs returns the URLs of failed rows in the order they were first
+// added, at most max of them (0 for all). A failed entry of a playlist that
+// also has downloaded entries, which the download would skip as a whole,
+// is returned with its own page in Entry instead; entries that failed
+// before their page was known are left out.
+func failedURLs(db *sql.DB, max int) ([]csvRow, error) {
+	rows, err := db.Query(`SELECT url, COALESCE(ytdlp_id, ''), COALESCE(webpage_url, ''),
+		EXISTS (SELECT 1 FROM tracks d WHERE d.url = t.url AND d.status IN (?, ?))
+		FROM tracks t WHERE status = ? AND COALESCE(url, '') <> '' ORDER BY id`, statusDownloaded, statusDuplicate, statusFailed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var urls []csvRow
+	seen := make(map[csvRow]bool)
+	for rows.Next() {
+		var r csvRow
+		var webpage string
+		var partial bool
+		if err := rows.Scan(&r.URL, &r.EntryID, &webpage, &partial); err != nil {
+			return nil, err
+		}
+		if partial {
+			if webpage == "" || r.EntryID == "" {
+				continue
+			}
+			r.Entry = webpage
+		} else {
+			r.EntryID = ""
+		}
+		if seen[r] {
 			continue
 		}
-		urls = append(urls, url)
+		seen[r] = true
+		urls = append(urls, r)
+		if max > 0 && len(urls) == max {
+			break
+		}
 	}
-	return urls, nil
+	return urls, rows.Err()
 }
 
 func main() {
-	csvPath := flag.String("csv", "urls.csv", "CSV file of URLs (first column)")
-	dbPath := flag.String("db", "tracks.db", "sqlite db path")
-	mp3Dir := flag.String("mp3dir", "./downloads/mp3", "directory to save mp3 files (default downloads/mp3)")
-	dataDir := flag.String("datadir", "./data/json", "directory to save info.json blobs (default data/json)")
-	workers := flag.Int("workers", 3, "concurrent workers")
-	flag.Parse()
+	// retry-failed runs the download pipeline below on the failed rows of
+	// the DB instead of an input file
+	args := os.Args[1:]
+	retryFailed := false
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "rescan", "scan":
+			runRescan(os.Args[2:])
+			return
+		case "export-playlist":
+			runExportPlaylist(os.Args[2:])
+			return
+		case "schema-sql":
+			runSchemaSQL(os.Args[2:])
+			return
+		case "convert":
+			runConvert(os.Args[2:])
+			return
+		case "list":
+			runList(os.Args[2:])
+			return
+		case "verify":
+			runVerify(os.Args[2:])
+			return
+		case "export":
+			runExport(os.Args[2:])
+			return
+		case "delete":
+			runDelete(os.Args[2:])
+			return
+		case "search":
+			runSearch(os.Args[2:])
+			return
+		case "stats":
+			runLibraryStats(os.Args[2:])
+			return
+		case "retry-failed":
+			retryFailed = true
+			args = os.Args[2:]
+		}
+	}
 
-	// create default directories
-	if err := os.MkdirAll(*mp3Dir, 0o755); err != nil {
-		fmt.Println("cannot create mp3 dir:", err)
+	ytdlpBin := flag.String("ytdlp", "yt-dlp", "yt-dlp executable to run, by name on PATH or as a path")
+	var csvPaths stringList
+	flag.Var(&csvPaths, "csv", "CSV file of URLs (first column), or - for stdin; repeat to read several files in order (default urls.csv)")
+	inputFormat := flag.String("format", "csv", "format of the -csv input: csv, or txt for one URL per line")
+	dbPath := dbFlag(flag.CommandLine)
+	mp3Dir := pathFlag(flag.CommandLine, "mp3dir", envMp3Dir, defaultMp3Dir, "directory to save mp3 files")
+	dataDir := pathFlag(flag.CommandLine, "datadir", envDataDir, defaultDataDir, "directory to save info.json blobs")
+	workers := flag.Int("workers", 3, "concurrent workers")
+	urlCol := flag.Int("url-column", 0, "0-based CSV column holding the URL")
+	notesCol := flag.Int("notes-column", -1, "0-based CSV column with notes to store with each track")
+	priorityCol := flag.Int("priority-column", -1, "0-based CSV column holding an integer priority; higher priorities are downloaded first (default: CSV order)")
+	perHost := flag.Int("per-host", 1, "maximum workers downloading from the same site at once; 0 for no limit")
+	writeResultFile := flag.Bool("write-result", false, "write <name>.result.json next to each info.json with the final paths, status, duration and checksum")
+	sleep := flag.Duration("sleep", 0, "pause of each worker between downloads, e.g. 3s (default: none)")
+	sleepJitter := flag.Duration("sleep-jitter", 0, "vary -sleep randomly by up to this much either way, e.g. 2s")
+	rampUp := flag.Duration("ramp-up", 0, "delay between starting each worker, e.g. 5s (default: start all at once)")
+	livePolicy := flag.String("live-policy", livePolicySkip, "what to do with live streams: skip, from-start or wait")
+	conflictPolicy := flag.String("conflict-policy", conflictOverwrite, "when a track already exists: overwrite, skip or keep-metadata")
+	measureLoudness := flag.Bool("measure-loudness", false, "measure integrated loudness (LUFS) with ffmpeg after each download")
+	audioFormat := flag.String("audioformat", "mp3", "audio format of the primary file: mp3, flac, opus, m4a or wav")
+	audioQuality := flag.String("audioquality", "0", "yt-dlp audio quality: 0 (best) to 10 VBR, or a bitrate like 128K")
+	formats := flag.String("formats", "mp3", "comma separated audio formats to produce, e.g. mp3,opus; the first is the primary file")
+	sponsorBlock := flag.Bool("sponsorblock", false, "cut SponsorBlock segments of YouTube videos out of the file")
+	sponsorBlockCats := flag.String("sponsorblock-categories", "sponsor,selfpromo", "comma separated SponsorBlock categories -sponsorblock cuts, see yt-dlp --sponsorblock-remove")
+	section := flag.String("section", "", "only download this time range of each video, as START-END in [HH:]MM:SS, e.g. 1:30-2:45 (default: whole video)")
+	video := flag.Bool("video", false, "download the best video with audio and keep it as mp4/webm/mkv instead of extracting audio")
+	formatID := flag.String("format-id", "", "download this exact yt-dlp format_id (see yt-dlp -F) and keep it as-is")
+	formatSort := flag.String("format-sort", "", "yt-dlp --format-sort order for picking the best format, e.g. acodec:opus,abr (default: yt-dlp's)")
+	formatIDExtract := flag.Bool("format-id-extract", false, "with -format-id, still extract audio to the first -formats entry")
+	var allowUploaders, denyUploaders stringList
+	flag.Var(&allowUploaders, "allow-uploader", "only keep tracks whose uploader matches (substring, or re:<regex>; repeatable)")
+	flag.Var(&denyUploaders, "deny-uploader", "skip tracks whose uploader matches (substring, or re:<regex>; repeatable)")
+	requireFields := flag.String("require-fields", "", "comma separated info.json fields that must be non-empty, e.g. title,uploader; tracks missing one fail with missing-metadata")
+	deleteIncomplete := flag.Bool("delete-incomplete", false, "with -require-fields, also delete the files of tracks that fail the check")
+	embedMetadata := flag.Bool("embed-metadata", false, "write title, artist and other tags into the audio file")
+	embedThumbnail := flag.Bool("embed-thumbnail", false, "embed the video thumbnail as cover art")
+	maxTagLength := flag.Int("max-tag-length", 0, "with -embed-metadata, truncate the embedded title/description tags to this many characters (default: no limit)")
+	noMtime := flag.Bool("no-mtime", false, "give output files the download time as mtime instead of the upload time")
+	nameTemplate := flag.String("name-template", "%(id)s", "yt-dlp output template for file names, without extension, e.g. \"%(uploader)s - %(title)s\"")
+	verifyFiles := flag.Bool("verify-files", false, "before skipping a downloaded URL, check that its file still exists and download it again if not")
+	overwrite := flag.Bool("overwrite", false, "download URLs again even if already downloaded, replacing their files and rows")
+	skipFailed := flag.Bool("skip-failed", false, "also skip URLs whose row has status failed instead of trying them again")
+	retryAfter := flag.Duration("retry-after", 0, "with -skip-failed, try failed URLs again once their last attempt is this old, e.g. 168h (default: never)")
+	byUploader := flag.Bool("by-uploader", false, "put audio files in a subdirectory of -mp3dir named after the uploader")
+	noHash := flag.Bool("no-hash", false, "do not compute the sha256 of downloaded files")
+	var extraArgs stringList
+	flag.Var(&extraArgs, "ytdlp-arg", "extra argument passed to yt-dlp as is, after the built-in ones; repeat for each argument, e.g. -ytdlp-arg --geo-bypass")
+	var matchFilters stringList
+	flag.Var(&matchFilters, "match-filter", "yt-dlp --match-filter expression, e.g. \"view_count > 1000\" or \"!is_live\"; repeat to require several")
+	minDuration := flag.Duration("min-duration", 0, "skip videos shorter than this, e.g. 1m, or without a known duration (default: no limit)")
+	playlistItems := flag.String("playlist-items", "", "only download these entries of playlists, e.g. 1-10,15,20- (default: all)")
+	dateAfter := flag.String("dateafter", "", "only download videos uploaded on or after this date, as YYYYMMDD (default: no limit)")
+	dateBefore := flag.String("datebefore", "", "only download videos uploaded on or before this date, as YYYYMMDD (default: no limit)")
+	maxDuration := flag.Duration("max-duration", 0, "skip videos this long or longer, e.g. 15m, or without a known duration (default: no limit)")
+	dedupContent := flag.Bool("dedup-content", false, "mark downloads whose audio matches an existing track by sha256 as duplicate and delete their files")
+	strictAudio := flag.Bool("strict-audio", false, "check each download's file header and fail the job if it is not audio (e.g. a saved HTML error page)")
+	verifyDownload := flag.Bool("verify-download", false, "read each downloaded file back and fail the job if it is empty or unreadable")
+	userAgent := flag.String("user-agent", "", "user agent yt-dlp sends (default: yt-dlp's own)")
+	cookies := flag.String("cookies", "", "Netscape-format cookies file for logged-in or age-restricted videos")
+	cookiesFromBrowser := flag.String("cookies-from-browser", "", "load cookies from this browser, e.g. firefox or chrome:Profile 1")
+	var headers stringList
+	flag.Var(&headers, "add-header", "extra HTTP header as \"Name:Value\" (repeatable)")
+	var cacheDir string
+	flag.Var(pathValue{&cacheDir}, "cache-dir", "directory for downloads in progress, kept under stable names so a restarted run resumes them (default: the system temp dir)")
+	var archive string
+	flag.Var(pathValue{&archive}, "archive", "yt-dlp download archive file: videos listed in it are skipped and new downloads are added; created if missing")
+	keepTemp := flag.Bool("keep-temp", false, "keep the partial files of failed downloads, so the next attempt or run continues them")
+	dirMode := flag.String("dir-mode", "", "octal permissions for directories the tool creates, e.g. 0775 for group-writable output (default: 0755 minus the umask)")
+	limitRate := flag.String("limit-rate", "", "maximum download rate per worker, e.g. 500K or 2M (default: unlimited)")
+	timeout := flag.Duration("timeout", 0, "kill a download that takes longer than this, e.g. 10m, and mark it failed (default: no limit)")
+	retries := flag.Int("retries", 3, "retry a download this many times when yt-dlp fails, waiting 2s, 4s, 8s, ... in between")
+	proxy := flag.String("proxy", "", "proxy for yt-dlp: http://, https:// or socks5:// URL (default: none; yt-dlp then uses $HTTPS_PROXY/$HTTP_PROXY and $NO_PROXY itself)")
+	proxyList := flag.String("proxy-list", "", "file with one proxy URL per line; jobs rotate through them")
+	stallThreshold := flag.Duration("db-stall-threshold", 30*time.Second, "log database writes that take longer than this; stalls are only reported, the writer is not restarted (0 disables)")
+	jsonLogFile := flag.String("json-log-file", "", "also write the log as JSON lines to this file")
+	jsonLogMaxMB := flag.Int64("json-log-max-mb", 10, "rotate the JSON log to <file>.1 once it exceeds this many MB (0 never rotates)")
+	maxDownloads := flag.Int64("max-downloads", 0, "stop taking new URLs once this many tracks have been downloaded in this run (default: no limit)")
+	head := flag.Int("head", 0, "only process the first N URLs of the CSV that still need downloading")
+	tail := flag.Int("tail", 0, "only process the last N URLs of the CSV that still need downloading")
+	allowAnyURL := flag.Bool("allow-any-url", false, "pass input that is not an http(s) URL to yt-dlp anyway, e.g. ytsearch:<terms>; by default it is recorded as invalid-url")
+	excludeFile := flag.String("exclude-file", "", "file of URLs never to download, one per line; matching input URLs are recorded as skipped-excluded")
+	interactive := flag.Bool("interactive", false, "ask before downloading playlists or picking among several audio formats (needs a terminal)")
+	yes := flag.Bool("yes", false, "with -interactive, take the default answer to every question without asking")
+	var dests stringList
+	flag.Var(&dests, "dest", "extra directory that gets a copy of every downloaded audio file (repeatable)")
+	dryRun := flag.Bool("dry-run", false, "only list which URLs would be downloaded (NEW) or skipped; starts no downloads and writes nothing")
+	checkpointPath := flag.String("checkpoint", "", "record the last processed CSV line in this file and resume after it on the next run")
+	backupDB := flag.Bool("backup-db", true, "copy the DB to <db>.bak-<timestamp> before upgrading its schema")
+	quiet := flag.Bool("quiet", false, "hide yt-dlp's output; errors are still recorded in error_text")
+	progress := flag.Bool("progress", false, "show a progress bar of completed URLs instead of yt-dlp's output (only on a terminal)")
+	summaryJSON := flag.Bool("json", false, "print the end-of-run summary as JSON")
+	logFormat := flag.String("log-format", "text", "console log format: text, or json for one JSON object per line")
+	maxRetry := flag.Int("max", 0, "retry-failed: retry at most this many failed URLs (default: all)")
+	configPath := pathFlag(flag.CommandLine, "config", envConfig, "", "TOML file setting any of these flags, e.g. workers = 4; flags given here override it")
+	verbose := flag.Bool("verbose", false, "log every effective setting at startup and the yt-dlp command line of each download")
+	_ = flag.CommandLine.Parse(args)
+	var fromConfig map[string]bool
+	if *configPath != "" {
+		var err error
+		if fromConfig, err = applyConfig(flag.CommandLine, *configPath); err != nil {
+			fmt.Println("config error:", err)
+			os.Exit(1)
+		}
+	}
+	if err := setLogFormat(*logFormat); err != nil {
+		fmt.Println("invalid -log-format:", err)
 		os.Exit(1)
 	}
-	if err := os.MkdirAll(*dataDir, 0o755); err != nil {
-		fmt.Println("cannot create data dir:", err)
+	stats := newRunStats()
+
+	switch *livePolicy {
+	case livePolicySkip, livePolicyFromStart, livePolicyWait:
+	default:
+		mainLog.Errorf("invalid -live-policy: %v", *livePolicy)
 		os.Exit(1)
 	}
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
 
-	db, err := ensureDB(*dbPath)
+	// the extension is always added by us, so it may be given or not
+	tmpl := strings.TrimSuffix(strings.TrimSpace(*nameTemplate), ".%(ext)s")
+	if tmpl == "" || strings.ContainsAny(tmpl, `/\`) || !strings.Contains(tmpl, "%(") {
+		mainLog.Errorf("invalid -name-template, want a yt-dlp template without directories like %%(title)s: %v", *nameTemplate)
+		os.Exit(1)
+	}
+	if *sleep < 0 || *sleepJitter < 0 || *sleepJitter > *sleep {
+		mainLog.Errorf("invalid -sleep/-sleep-jitter, want positive durations with the jitter no larger than the sleep")
+		os.Exit(1)
+	}
+	if *maxDownloads < 0 {
+		mainLog.Errorf("invalid -max-downloads, want 0 or more: %d", *maxDownloads)
+		os.Exit(1)
+	}
+	if *perHost < 0 {
+		mainLog.Errorf("invalid -per-host, want 0 or more: %d", *perHost)
+		os.Exit(1)
+	}
+	dirPerm, err := parseDirMode(*dirMode)
+	if err != nil {
+		mainLog.Errorf("%v", err)
+		os.Exit(1)
+	}
+	clip, err := parseSection(*section)
+	if err != nil {
+		mainLog.Errorf("invalid -section, want START-END like 1:30-2:45 or 1:02:03-1:05:00: %v", err)
+		os.Exit(1)
+	}
+	if clip != "" {
+		// clips of one video must not replace each other's files
+		tmpl += "_" + strings.ReplaceAll(clip, ":", ".")
+	}
+	if *overwrite && *conflictPolicy == conflictSkip {
+		mainLog.Errorf("-overwrite cannot be combined with -conflict-policy skip")
+		os.Exit(1)
+	}
+	for _, a := range extraArgs {
+		// the tool finds the downloaded files through its own output template
+		name, _, _ := strings.Cut(a, "=")
+		if name == "-o" || name == "--output" || name == "-P" || name == "--paths" {
+			mainLog.Errorf("-ytdlp-arg %s would move the output away from the tool, use -mp3dir, -datadir or -name-template", a)
+			os.Exit(1)
+		}
+	}
+	if len(extraArgs) > 0 {
+		mainLog.Infof("passing extra arguments to yt-dlp: %s", strings.Join(extraArgs, " "))
+	}
+	if *minDuration < 0 || *maxDuration < 0 || (*maxDuration > 0 && *minDuration >= *maxDuration) {
+		mainLog.Errorf("invalid -min-duration/-max-duration, want positive durations with min below max")
+		os.Exit(1)
+	}
+	if explicit["playlist-items"] {
+		if err := checkPlaylistItems(*playlistItems); err != nil {
+			mainLog.Errorf("invalid -playlist-items: %v", err)
+			os.Exit(1)
+		}
+	}
+	after, err := parseUploadDate(*dateAfter)
+	if err != nil {
+		mainLog.Errorf("invalid -dateafter: %v", err)
+		os.Exit(1)
+	}
+	before, err := parseUploadDate(*dateBefore)
 	if err != nil {
-		fmt.Println("db error:", err)
+		mainLog.Errorf("invalid -datebefore: %v", err)
+		os.Exit(1)
+	}
+	if !after.IsZero() && !before.IsZero() && before.Before(after) {
+		mainLog.Errorf("-datebefore %s is before -dateafter %s", *dateBefore, *dateAfter)
+		os.Exit(1)
+	}
+	if explicit["format-sort"] && strings.TrimSpace(*formatSort) == "" {
+		mainLog.Errorf("-format-sort needs a sort order, e.g. acodec:opus")
+		os.Exit(1)
+	}
+	if *formatSort != "" && *formatID != "" {
+		mainLog.Errorf("-format-sort has no effect with -format-id, which picks the format itself")
+		os.Exit(1)
+	}
+	if *dedupContent && *noHash {
+		mainLog.Errorf("-dedup-content compares sha256 digests and cannot be used with -no-hash")
+		os.Exit(1)
+	}
+	if *maxRetry < 0 || (explicit["max"] && !retryFailed) {
+		mainLog.Errorf("-max needs the retry-failed command and a positive count")
+		os.Exit(1)
+	}
+	if *retryAfter < 0 || (*retryAfter > 0 && !*skipFailed) {
+		mainLog.Errorf("-retry-after needs -skip-failed and a positive duration")
+		os.Exit(1)
+	}
+	if retryFailed && *skipFailed {
+		mainLog.Errorf("retry-failed cannot be combined with -skip-failed")
+		os.Exit(1)
+	}
+	if retryFailed && (explicit["csv"] || flag.NArg() > 0) {
+		mainLog.Errorf("retry-failed takes its URLs from the DB, not -csv or arguments")
+		os.Exit(1)
+	}
+
+	if len(csvPaths) > 1 && *checkpointPath != "" {
+		// checkpoint lines do not say which file they belong to
+		mainLog.Errorf("-checkpoint needs a single -csv file")
+		os.Exit(1)
+	}
+	stdinInputs := 0
+	for _, p := range csvPaths {
+		if p == "-" {
+			stdinInputs++
+		}
+	}
+	if stdinInputs > 1 {
+		mainLog.Errorf("-csv - can only be given once")
 		os.Exit(1)
 	}
-	defer db.Close()
 
-	urls, err := readCSVUrls(*csvPath)
+	// the default -csv is only read without URLs as arguments, so a
+	// one-off download does not pull in the whole batch
+	readInput := !retryFailed
+	if readInput && len(csvPaths) == 0 {
+		csvPaths = stringList{"urls.csv"}
+		if _, err := os.Stat(csvPaths[0]); err != nil || flag.NArg() > 0 {
+			readInput = false
+		}
+	}
+	if !readInput && !retryFailed && flag.NArg() == 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "no input: %s not found and no URLs given\n\n", csvPaths[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [url ...]\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	ytdlpPath := *ytdlpBin
+	if !*dryRun {
+		var err error
+		if ytdlpPath, err = exec.LookPath(*ytdlpBin); err != nil {
+			mainLog.Errorf("yt-dlp not found, install it or point -ytdlp at it: %v", err)
+			os.Exit(1)
+		}
+	}
+	if *inputFormat != "csv" && *inputFormat != "txt" {
+		mainLog.Errorf("invalid -format, want csv or txt: %v", *inputFormat)
+		os.Exit(1)
+	}
+	if *inputFormat == "txt" && *priorityCol >= 0 {
+		mainLog.Errorf("-priority-column needs -format csv")
+		os.Exit(1)
+	}
+	if *inputFormat == "txt" && *notesCol >= 0 {
+		mainLog.Errorf("-notes-column needs -format csv")
+		os.Exit(1)
+	}
+	if *urlCol < 0 || (*inputFormat == "txt" && *urlCol > 0) {
+		mainLog.Errorf("invalid -url-column, want a 0-based column of a -format csv input: %d", *urlCol)
+		os.Exit(1)
+	}
+	formatList, err := parseFormats(*formats)
+	if err != nil {
+		mainLog.Errorf("invalid -formats: %v", err)
+		os.Exit(1)
+	}
+	var sponsorCategories string
+	if *sponsorBlock {
+		cats := splitList(*sponsorBlockCats)
+		for _, c := range cats {
+			if !sponsorBlockCategories[strings.TrimPrefix(c, "-")] {
+				mainLog.Errorf("invalid -sponsorblock-categories, unknown category %q", c)
+				os.Exit(1)
+			}
+		}
+		if len(cats) == 0 {
+			mainLog.Errorf("-sponsorblock needs at least one category")
+			os.Exit(1)
+		}
+		sponsorCategories = strings.Join(cats, ",")
+	} else if explicit["sponsorblock-categories"] {
+		mainLog.Errorf("-sponsorblock-categories needs -sponsorblock")
+		os.Exit(1)
+	}
+	if *video && (explicit["audioformat"] || explicit["audioquality"] || *formatIDExtract || *strictAudio) {
+		mainLog.Errorf("-video keeps the download as-is and cannot be combined with -audioformat, -audioquality, -format-id-extract or -strict-audio")
+		os.Exit(1)
+	}
+	if explicit["audioformat"] {
+		primary, err := parseFormats(*audioFormat)
+		if err != nil || len(primary) != 1 {
+			mainLog.Errorf("invalid -audioformat: %v", *audioFormat)
+			os.Exit(1)
+		}
+		// -audioformat picks the primary file; any -formats are extras
+		if !explicit["formats"] {
+			formatList = nil
+		}
+		formatList = append(primary, slices.DeleteFunc(formatList, func(f string) bool { return f == primary[0] })...)
+	}
+	if !audioQualityRe.MatchString(*audioQuality) {
+		mainLog.Errorf("invalid -audioquality, want 0-10 or a bitrate like 128K: %v", *audioQuality)
+		os.Exit(1)
+	}
+	if *limitRate != "" && !limitRateRe.MatchString(*limitRate) {
+		mainLog.Errorf("invalid -limit-rate, want a number optionally followed by K or M: %v", *limitRate)
+		os.Exit(1)
+	}
+	if *retries < 0 {
+		mainLog.Errorf("invalid -retries: %v", *retries)
+		os.Exit(1)
+	}
+	if !validConflictPolicy(*conflictPolicy) {
+		mainLog.Errorf("invalid -conflict-policy: %v", *conflictPolicy)
+		os.Exit(1)
+	}
+	uploaders, err := newUploaderFilter(allowUploaders, denyUploaders)
 	if err != nil {
-		fmt.Println("csv error:", err)
+		mainLog.Errorf("invalid uploader filter: %v", err)
+		os.Exit(1)
+	}
+	if *maxTagLength < 0 {
+		mainLog.Errorf("invalid -max-tag-length: %v", *maxTagLength)
+		os.Exit(1)
+	}
+	if *maxTagLength > 0 && !*embedMetadata {
+		// the shortened meta_* fields only end up in the file through --embed-metadata
+		mainLog.Errorf("-max-tag-length needs -embed-metadata")
+		os.Exit(1)
+	}
+	if *head < 0 || *tail < 0 {
+		mainLog.Errorf("-head and -tail must not be negative")
+		os.Exit(1)
+	}
+	if *head > 0 && *tail > 0 {
+		mainLog.Errorf("-head and -tail cannot be combined")
+		os.Exit(1)
+	}
+	if (*head > 0 || *tail > 0) && *checkpointPath != "" {
+		// the checkpoint assumes every line up to it was looked at
+		mainLog.Errorf("-head/-tail cannot be combined with -checkpoint")
+		os.Exit(1)
+	}
+	if *cookies != "" && *cookiesFromBrowser != "" {
+		mainLog.Errorf("-cookies and -cookies-from-browser cannot be combined")
+		os.Exit(1)
+	}
+	if *cookies != "" {
+		f, err := os.Open(*cookies)
+		if err != nil {
+			mainLog.Errorf("cannot read -cookies file: %v", err)
+			os.Exit(1)
+		}
+		f.Close()
+	}
+	for _, h := range headers {
+		if name, _, ok := strings.Cut(h, ":"); !ok || strings.TrimSpace(name) == "" {
+			mainLog.Errorf("invalid -add-header, want Name:Value: %v", h)
+			os.Exit(1)
+		}
+	}
+
+	if *proxy != "" && *proxyList != "" {
+		mainLog.Errorf("-proxy and -proxy-list cannot be combined")
 		os.Exit(1)
 	}
+	if *proxy != "" {
+		if err := validateProxy(*proxy); err != nil {
+			mainLog.Errorf("invalid -proxy: %v", err)
+			os.Exit(1)
+		}
+	}
+	var proxies *proxyPool
+	if *proxyList != "" {
+		if proxies, err = loadProxyList(*proxyList); err != nil {
+			mainLog.Errorf("invalid -proxy-list: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if *jsonLogFile != "" {
+		closeLog, err := openJSONLog(*jsonLogFile, *jsonLogMaxMB<<20)
+		if err != nil {
+			mainLog.Errorf("cannot open json log: %v", err)
+			os.Exit(1)
+		}
+		defer closeLog()
+	}
+	if *verbose {
+		logSettings(flag.CommandLine, fromConfig)
+	}
+
+	var db *sql.DB
+	if *dryRun {
+		// read-only, and only if it exists: a dry run writes nothing
+		if db, err = openExistingDB(*dbPath); err != nil {
+			mainLog.Errorf("db error: %v", err)
+			os.Exit(1)
+		}
+	} else {
+		// create default directories
+		if err := mkdirAll(*mp3Dir, dirPerm); err != nil {
+			mainLog.Errorf("cannot create mp3 dir: %v", err)
+			os.Exit(1)
+		}
+		if err := mkdirAll(*dataDir, dirPerm); err != nil {
+			mainLog.Errorf("cannot create data dir: %v", err)
+			os.Exit(1)
+		}
+		if archive != "" {
+			if err := createArchive(archive, dirPerm); err != nil {
+				mainLog.Errorf("cannot create archive: %v", err)
+				os.Exit(1)
+			}
+		}
+		if db, err = ensureDB(*dbPath, *backupDB); err != nil {
+			mainLog.Errorf("db error: %v", err)
+			os.Exit(1)
+		}
+	}
+	if db != nil {
+		defer db.Close()
+	}
+
+	// URLs given as arguments come first and have no CSV line
+	var rows []csvRow
+	for _, u := range flag.Args() {
+		rows = append(rows, csvRow{URL: u})
+	}
+	// every file is read before the first download, so a bad path fails
+	// the whole run; the seen map below drops URLs listed in several files
+	for _, p := range csvPaths {
+		if !readInput {
+			break
+		}
+		var inputRows []csvRow
+		if *inputFormat == "txt" {
+			inputRows, err = readTxtUrls(p)
+		} else {
+			inputRows, err = readCSVUrls(p, *urlCol, *priorityCol, *notesCol)
+		}
+		if err != nil {
+			if pathErr := (*fs.PathError)(nil); !errors.As(err, &pathErr) {
+				// parse errors do not name the file
+				err = fmt.Errorf("%s: %w", p, err)
+			}
+			mainLog.Errorf("input error: %v", err)
+			os.Exit(1)
+		}
+		rows = append(rows, inputRows...)
+	}
+	if retryFailed && db != nil {
+		if rows, err = failedURLs(db, *maxRetry); err != nil {
+			mainLog.Errorf("db error: %v", err)
+			os.Exit(1)
+		}
+		mainLog.Infof("retrying %d failed urls", len(rows))
+	}
+
+	resumeLine := 0
+	if *checkpointPath != "" {
+		resumeLine, err = readCheckpoint(*checkpointPath)
+		if err != nil {
+			mainLog.Errorf("checkpoint error: %v", err)
+			os.Exit(1)
+		}
+		if resumeLine > 0 {
+			mainLog.Infof("resuming after CSV line %d", resumeLine)
+		}
+	}
+
+	var excluded map[string]bool
+	if *excludeFile != "" {
+		if excluded, err = readExcludeFile(*excludeFile); err != nil {
+			mainLog.Errorf("exclude file error: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	// for -dry-run: every input URL in order, with why it is skipped
+	type plannedURL struct{ url, skip string }
+	var planned []plannedURL
 
 	seen := make(map[string]struct{})
-	jobs := make(chan Job, len(urls))
-	for _, u := range urls {
-		u = strings.TrimSpace(u)
-		if u == "" {
+	var pending []Job
+	for _, row := range rows {
+		if row.Line > 0 && row.Line <= resumeLine {
 			continue
 		}
-		if _, ok := seen[u]; ok {
+		raw := strings.TrimSpace(row.URL)
+		if raw == "" {
 			continue
 		}
-		seen[u] = struct{}{}
+		// share links of one video are queued, and stored, as one URL
+		u := canonicalURL(raw)
+		key := u
+		if row.Entry != "" {
+			key = row.Entry
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
 
-		// skip if already in DB
-		var exists int
-		err := db.QueryRow("SELECT 1 FROM tracks WHERE url = ? AND status = 'downloaded' LIMIT 1", u).Scan(&exists)
-		if err == nil {
-			fmt.Printf("[main] skipping already-downloaded url: %s\n", u)
+		if !*allowAnyURL {
+			if err := checkURL(u); err != nil {
+				if *dryRun {
+					planned = append(planned, plannedURL{u, "invalid url"})
+					continue
+				}
+				mainLog.Warnf("invalid url %s: %v", sanitizeForLog(u), err)
+				t := Track{URL: u, Status: statusInvalidURL, ErrText: err.Error(), Notes: row.Notes}
+				stats.record(0, t)
+				if err := upsertTrack(db, *conflictPolicy, t); err != nil {
+					mainLog.Errorf("db insert failed: %v", err)
+				}
+				continue
+			}
+		}
+		if excluded[normalizeURL(u)] {
+			if *dryRun {
+				planned = append(planned, plannedURL{u, "excluded"})
+				continue
+			}
+			mainLog.Infof("skipping excluded url: %s", sanitizeForLog(u))
+			stats.skipped(0)
+			if err := upsertTrack(db, *conflictPolicy, Track{URL: u, Status: statusSkippedExcluded, Notes: row.Notes}); err != nil {
+				mainLog.Errorf("db insert failed: %v", err)
+			}
 			continue
 		}
-		jobs <- Job{URL: u}
+
+		// skip if already in DB; a failed playlist entry from retry-failed
+		// is not, though its playlist is
+		if row.Entry == "" {
+			done, err := alreadyDownloaded(db, u, clip, *verifyFiles)
+			if err == nil && !done && *dryRun && raw != u {
+				// the DB of a dry run is not migrated, so it can still hold
+				// the URL as it was before canonicalization
+				done, err = alreadyDownloaded(db, raw, clip, *verifyFiles)
+			}
+			if err != nil {
+				mainLog.Warnf("db check failed for %s: %v", sanitizeForLog(u), err)
+			}
+			if done && !*overwrite {
+				if *dryRun {
+					planned = append(planned, plannedURL{u, "already downloaded"})
+					continue
+				}
+				mainLog.Infof("skipping already-downloaded url: %s", sanitizeForLog(u))
+				stats.skipped(0)
+				continue
+			}
+		}
+		if *skipFailed {
+			failed, err := failedRecently(db, u, clip, *retryAfter)
+			if err != nil {
+				mainLog.Warnf("db check failed for %s: %v", sanitizeForLog(u), err)
+			}
+			if failed {
+				if *dryRun {
+					planned = append(planned, plannedURL{u, "failed before"})
+					continue
+				}
+				mainLog.Infof("skipping url that failed before: %s", sanitizeForLog(u))
+				stats.skipped(0)
+				continue
+			}
+		}
+		planned = append(planned, plannedURL{url: key})
+		pending = append(pending, Job{URL: u, Priority: row.Priority, Line: row.Line, Notes: row.Notes, Entry: row.Entry, EntryID: row.EntryID})
+	}
+	// the window is taken after dedup, so it counts URLs that will
+	// actually be downloaded
+	if *head > 0 && len(pending) > *head {
+		pending = pending[:*head]
+	}
+	if *tail > 0 && len(pending) > *tail {
+		pending = pending[len(pending)-*tail:]
+	}
+	if *dryRun {
+		queued := make(map[string]bool, len(pending))
+		for _, job := range pending {
+			queued[job.page()] = true
+		}
+		newCount, skipCount := 0, 0
+		for _, p := range planned {
+			if p.skip == "" && !queued[p.url] {
+				p.skip = "outside -head/-tail"
+			}
+			if p.skip == "" {
+				fmt.Println("NEW ", sanitizeForLog(p.url))
+				newCount++
+			} else {
+				fmt.Printf("SKIP (%s) %s\n", p.skip, sanitizeForLog(p.url))
+				skipCount++
+			}
+		}
+		fmt.Printf("%d new, %d skipped\n", newCount, skipCount)
+		return
+	}
+	if *priorityCol >= 0 {
+		// higher priority first; CSV order among equal priorities
+		sort.SliceStable(pending, func(i, j int) bool {
+			return pending[i].Priority > pending[j].Priority
+		})
+	}
+	jobs := make(chan Job, len(pending))
+	for _, job := range pending {
+		jobs <- job
 	}
 	close(jobs)
 
-	var wg sync.WaitGroup
-	wg.Add(*workers)
-	for i := 0; i < *workers; i++ {
-		go worker(i+1, db, *mp3Dir, *dataDir, jobs, &wg)
+	opts := Options{
+		YtDlp:              ytdlpPath,
+		Mp3Dir:             *mp3Dir,
+		DataDir:            *dataDir,
+		LivePolicy:         *livePolicy,
+		ConflictPolicy:     *conflictPolicy,
+		MeasureLoudness:    *measureLoudness,
+		Formats:            formatList,
+		AudioQuality:       strings.ToUpper(*audioQuality),
+		UserAgent:          *userAgent,
+		Headers:            headers,
+		Cookies:            *cookies,
+		CookiesFromBrowser: *cookiesFromBrowser,
+		VerifyDownload:     *verifyDownload,
+		MaxTagLength:       *maxTagLength,
+		NoMtime:            *noMtime,
+		NoHash:             *noHash,
+		DedupContent:       *dedupContent,
+		MatchFilter:        matchFilter(matchFilters, *minDuration, *maxDuration),
+		PlaylistItems:      strings.ReplaceAll(*playlistItems, " ", ""),
+		DateAfter:          *dateAfter,
+		DateBefore:         *dateBefore,
+		CacheDir:           cacheDir,
+		Archive:            archive,
+		KeepTemp:           *keepTemp,
+		ExtraArgs:          extraArgs,
+		Video:              *video,
+		SponsorBlock:       sponsorCategories,
+		Section:            clip,
+		DirMode:            dirPerm,
+		Hosts:              newHostLimiter(*perHost),
+		Sleep:              *sleep,
+		SleepJitter:        *sleepJitter,
+		WriteResult:        *writeResultFile,
+		EmbedMetadata:      *embedMetadata,
+		EmbedThumbnail:     *embedThumbnail,
+		StrictAudio:        *strictAudio,
+		Dests:              dests,
+		Prompt:             newPrompter(*interactive, *yes),
+		LimitRate:          *limitRate,
+		Timeout:            *timeout,
+		Retries:            *retries,
+		Proxies:            proxies,
+		Stats:              stats,
+		NameTemplate:       tmpl,
+		ByUploader:         *byUploader,
+		Overwrite:          *overwrite,
+		SkipFailed:         *skipFailed,
+		RetryAfter:         *retryAfter,
+		VerifyFiles:        *verifyFiles,
+		Quiet:              *quiet,
+		Verbose:            *verbose,
+		Proxy:              *proxy,
+		RequireFields:      splitList(*requireFields),
+		DeleteIncomplete:   *deleteIncomplete,
+		FormatID:           strings.TrimSpace(*formatID),
+		FormatIDExtract:    *formatIDExtract,
+		FormatSort:         strings.TrimSpace(*formatSort),
+		Uploaders:          uploaders,
+	}
+
+	var wd *writeWatchdog
+	if *stallThreshold > 0 {
+		wd = newWriteWatchdog(*stallThreshold)
+		stop := make(chan struct{})
+		defer close(stop)
+		go wd.run(stop)
+	}
+
+	var cp *checkpoint
+	if *checkpointPath != "" {
+		var lines []int
+		for _, job := range pending {
+			if job.Line > 0 {
+				lines = append(lines, job.Line)
+			}
+		}
+		lastLine := 0
+		if len(rows) > 0 {
+			lastLine = rows[len(rows)-1].Line
+		}
+		cp = newCheckpoint(*checkpointPath, resumeLine, lastLine, lines)
+		stop := make(chan struct{})
+		defer close(stop)
+		go cp.run(stop)
+
+		// on Ctrl-C, keep what has been finished so far
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sigs
+			if err := cp.save(); err != nil {
+				mainLog.Errorf("cannot write checkpoint: %v", err)
+			} else {
+				mainLog.Infof("interrupted, checkpoint saved to %s", *checkpointPath)
+			}
+			os.Exit(130)
+		}()
+	}
+
+	writer := newDBWriter(db, wd)
+	// the bar would only be noise in a log file or pipe
+	if *progress && consoleLog == nil && isTerminal(os.Stdout) {
+		bar = newProgressBar(os.Stdout, len(pending))
+	}
+	pool := newWorkerPool(jobs, *workers, *maxDownloads)
+	pool.run(*rampUp, func(id int) {
+		worker(id, db, opts, pool, writer, cp)
+	})
+	writer.close()
+	bar.end()
+	bar = nil
+	if left := pool.left.Load(); left > 0 {
+		mainLog.Infof("-max-downloads %d reached, %d urls left for the next run", *maxDownloads, left)
+	}
+	if err := cp.save(); err != nil {
+		mainLog.Errorf("cannot write checkpoint: %v", err)
+	}
+	mainLog.Infof("all done at %s", time.Now().Format(time.DateTime))
+	// under -log-format json stdout is for machines, so the summary is too
+	if err := stats.print(os.Stdout, *summaryJSON || consoleLog != nil); err != nil {
+		mainLog.Errorf("cannot print summary: %v", err)
 	}
-	wg.Wait()
-	fmt.Println("All done at", time.Now())
 }
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestCopyFileConcurrentReader reads the destination while copyFile writes
+// it: the file must be either absent or complete, never partial.
+func TestCopyFileConcurrentReader(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.mp3")
+	want := bytes.Repeat([]byte("0123456789abcdef"), 1<<18) // 4 MiB
+	if err := os.WriteFile(src, want, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	outDir := filepath.Join(dir, "out")
+	if err := os.Mkdir(outDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(outDir, "track.mp3")
+
+	var done atomic.Bool
+	readerErr := make(chan error, 1)
+	go func() {
+		reads := 0
+		for {
+			finished := done.Load()
+			got, err := os.ReadFile(dst)
+			switch {
+			case errors.Is(err, fs.ErrNotExist):
+			case err != nil:
+				readerErr <- err
+				return
+			case !bytes.Equal(got, want):
+				readerErr <- errors.New("reader saw a partial file")
+				return
+			default:
+				reads++
+			}
+			if finished {
+				if reads == 0 {
+					readerErr <- errors.New("reader never saw the file")
+					return
+				}
+				readerErr <- nil
+				return
+			}
+		}
+	}()
+
+	if err := copyFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	done.Store(true)
+	if err := <-readerErr; err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "track.mp3" {
+		t.Fatalf("output dir holds %v, want only track.mp3", entries)
+	}
+}
+
+// TestMoveFileAcrossDevices makes the rename fail with EXDEV, as it does
+// across filesystems. moveFile must then copy: dst gets the source's
+// content, mode and mtime, the source is removed, and no temp file is left.
+func TestMoveFileAcrossDevices(t *testing.T) {
+	renamed := false
+	rename = func(oldpath, newpath string) error {
+		renamed = true
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+	}
+	t.Cleanup(func() { rename = os.Rename })
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.opus")
+	want := []byte("OggS fake audio")
+	if err := os.WriteFile(src, want, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(src, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(dir, "dst.opus")
+	if err := os.WriteFile(dst, []byte("older download"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := moveFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if !renamed {
+		t.Fatal("moveFile did not try to rename first")
+	}
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("dst holds %q, want %q", got, want)
+	}
+	if _, err := os.Stat(src); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("source still there after the move: %v", err)
+	}
+	fi, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o640 {
+		t.Errorf("dst mode %v, want %v", fi.Mode().Perm(), os.FileMode(0o640))
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Errorf("dst mtime %v, want %v", fi.ModTime(), mtime)
+	}
+	if tmps, _ := filepath.Glob(filepath.Join(dir, ".*.tmp")); len(tmps) > 0 {
+		t.Errorf("temp files left behind: %v", tmps)
+	}
+}
+
+// TestCopyFileFailureKeepsDst makes the copy fail halfway, reading a
+// directory as the source, and checks that dst is left as it was.
+func TestCopyFileFailureKeepsDst(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "not-a-file")
+	if err := os.Mkdir(src, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(dir, "dst.mp3")
+	want := []byte("earlier download")
+	if err := os.WriteFile(dst, want, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := copyFile(src, dst); err == nil {
+		t.Fatal("copying a directory succeeded")
+	}
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("dst holds %q after a failed copy, want %q", got, want)
+	}
+	if tmps, _ := filepath.Glob(filepath.Join(dir, ".*.tmp")); len(tmps) > 0 {
+		t.Errorf("temp files left behind: %v", tmps)
+	}
+}
+
+// TestMoveFile checks the rename path, which must remove the source.
+func TestMoveFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.mp3")
+	dst := filepath.Join(dir, "dst.mp3")
+	if err := os.WriteFile(src, []byte("ID3"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := moveFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(src); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("source still there after the move: %v", err)
+	}
+	if got, err := os.ReadFile(dst); err != nil || string(got) != "ID3" {
+		t.Errorf("dst = %q, %v; want \"ID3\"", got, err)
+	}
+}
+
+// TestBuildYtDlpArgsAudioFormat checks the extraction arguments for each
+// format of -audioformat, and that extra formats keep the original stream.
+func TestBuildYtDlpArgsAudioFormat(t *testing.T) {
+	const url = "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+	tests := []struct {
+		formats []string
+		quality string
+		want    []string
+	}{
+		{[]string{"mp3"}, "0", []string{"--extract-audio", "--audio-format", "mp3", "--audio-quality", "0"}},
+		{[]string{"flac"}, "0", []string{"--extract-audio", "--audio-format", "flac", "--audio-quality", "0"}},
+		{[]string{"opus"}, "128K", []string{"--extract-audio", "--audio-format", "opus", "--audio-quality", "128K"}},
+		{[]string{"m4a"}, "5", []string{"--extract-audio", "--audio-format", "m4a", "--audio-quality", "5"}},
+		{[]string{"wav"}, "0", []string{"--extract-audio", "--audio-format", "wav", "--audio-quality", "0"}},
+		{[]string{"opus", "mp3"}, "0", []string{"--extract-audio", "--audio-format", "opus", "--audio-quality", "0"}},
+	}
+	for _, tt := range tests {
+		opts := Options{Formats: tt.formats, AudioQuality: tt.quality}
+		args := buildYtDlpArgs(opts, "/tmp/job/%(id)s.%(ext)s", url)
+		i := slices.Index(args, "--extract-audio")
+		if i < 0 || i+len(tt.want) > len(args) || !slices.Equal(args[i:i+len(tt.want)], tt.want) {
+			t.Errorf("formats %v: args %q, want %q in order", tt.formats, args, tt.want)
+		}
+		if keep := slices.Contains(args, "--keep-video"); keep != (len(tt.formats) > 1) {
+			t.Errorf("formats %v: --keep-video given = %v", tt.formats, keep)
+		}
+		if args[len(args)-1] != url {
+			t.Errorf("formats %v: last argument %q, want the URL", tt.formats, args[len(args)-1])
+		}
+	}
+}
+
+// TestBuildYtDlpArgsVideo checks that -video downloads without extracting.
+func TestBuildYtDlpArgsVideo(t *testing.T) {
+	args := buildYtDlpArgs(Options{Video: true, Formats: []string{"mp3"}, AudioQuality: "0"}, "out.%(ext)s", "https://example.com/v")
+	if slices.Contains(args, "--extract-audio") || slices.Contains(args, "--audio-format") {
+		t.Errorf("-video args %q extract audio", args)
+	}
+}
+
+// TestHashFile hashes a fixture whose digest was taken with sha256sum.
+func TestHashFile(t *testing.T) {
+	const want = "a3457eb86484cd530975124f62f85c623740c892eb07f0d05ea3c41af0fe4acc"
+	sum, n, err := hashFile(filepath.Join("testdata", "fixture.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != want || n != 25 {
+		t.Errorf("hashFile = %s, %d bytes; want %s, 25 bytes", sum, n, want)
+	}
+	if _, _, err := hashFile(filepath.Join(t.TempDir(), "missing.mp3")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("hashing a missing file: %v, want ErrNotExist", err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Environment variables that replace the built-in defaults of -db, -mp3dir
+// and -datadir for every command, and of the downloader's -config; an
+// explicit flag still wins.
+const (
+	envDB      = "SHINY_SPORK_DB"
+	envMp3Dir  = "SHINY_SPORK_MP3DIR"
+	envDataDir = "SHINY_SPORK_DATADIR"
+	envConfig  = "SHINY_SPORK_CONFIG"
+)
+
+// pathValue is a flag.Value for file and directory paths that expands a
+// leading ~, which the shell leaves alone in -db=~/x or in variables.
+type pathValue struct{ p *string }
+
+func (v pathValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return *v.p
+}
+
+func (v pathValue) Set(s string) error {
+	*v.p = expandHome(s)
+	return nil
+}
+
+// pathFlag defines a path flag on fset whose default is $env when set, else
+// def.
+func pathFlag(fset *flag.FlagSet, name, env, def, usage string) *string {
+	if v := os.Getenv(env); v != "" {
+		def = v
+	}
+	p := new(string)
+	*p = expandHome(def)
+	fset.Var(pathValue{p}, name, usage+" (env "+env+")")
+	return p
+}
+
+// dbFlag defines the -db flag every command shares.
+func dbFlag(fset *flag.FlagSet) *string {
+	return pathFlag(fset, "db", envDB, defaultDBPath, "sqlite db path")
+}
+
+// expandHome replaces a leading ~ or ~/ with the user's home directory.
+// ~user is not supported and left as is, as is everything when the home
+// directory is unknown.
+func expandHome(p string) string {
+	if p != "~" && !strings.HasPrefix(p, "~/") && !strings.HasPrefix(p, `~`+string(filepath.Separator)) {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+	return filepath.Join(home, p[1:])
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runExportPlaylist writes an M3U8 playlist of the tracks in the DB.
+func runExportPlaylist(args []string) {
+	fset := flag.NewFlagSet("export-playlist", flag.ExitOnError)
+	dbPath := dbFlag(fset)
+	out := fset.String("o", "playlist.m3u8", "output file, or - for stdout")
+	status := statusFlag(fset, statusDownloaded, "only include tracks with this status")
+	uploader := fset.String("uploader", "", "only include tracks whose uploader contains this text (case-insensitive)")
+	absolute := fset.Bool("absolute", false, "write absolute paths instead of paths relative to the playlist")
+	_ = fset.Parse(args)
+
+	// exporting must not create or upgrade the DB it reads
+	db, err := openExistingDB(*dbPath)
+	if err != nil {
+		fmt.Println("db error:", err)
+		os.Exit(1)
+	}
+	var tracks []playlistTrack
+	if db != nil {
+		defer db.Close()
+		if tracks, err = playlistTracks(db, *status, *uploader); err != nil {
+			fmt.Println("db error:", err)
+			os.Exit(1)
+		}
+	}
+
+	// relative paths are resolved against the playlist's own directory
+	base := "."
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Println("cannot create playlist:", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		w = f
+		base = filepath.Dir(*out)
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "#EXTM3U")
+
+	n := 0
+	for _, t := range tracks {
+		entry, err := playlistPath(t.path, base, *absolute)
+		if err != nil {
+			fmt.Println("[export-playlist] skipping", sanitizeForLog(t.path)+":", err)
+			continue
+		}
+		name := t.title
+		if t.uploader != "" {
+			name = t.uploader + " - " + t.title
+		}
+		// a newline inside a title would end the #EXTINF line early
+		name = strings.Join(strings.Fields(name), " ")
+		fmt.Fprintf(bw, "#EXTINF:%d,%s\n%s\n", t.duration, name, entry)
+		n++
+	}
+	if err := bw.Flush(); err != nil {
+		fmt.Println("cannot write playlist:", err)
+		os.Exit(1)
+	}
+	if *out != "-" {
+		fmt.Printf("[export-playlist] wrote %d tracks to %s\n", n, *out)
+	}
+}
+
+// playlistTrack is a track export-playlist writes.
+type playlistTrack struct {
+	title, uploader, path string
+	duration              int64
+}
+
+// playlistTracks returns the tracks with status and a file, whose uploader
+// contains uploader (any if ""), in the order they were added.
+func playlistTracks(db *sql.DB, status Status, uploader string) ([]playlistTrack, error) {
+	query := "SELECT COALESCE(title, ''), COALESCE(uploader, ''), COALESCE(duration_seconds, 0), mp3_path FROM tracks WHERE status = ? AND COALESCE(mp3_path, '') <> ''"
+	params := []any{status}
+	if uploader != "" {
+		query += ` AND uploader LIKE ? ESCAPE '\'`
+		params = append(params, "%"+likeEscaper.Replace(uploader)+"%")
+	}
+	rows, err := db.Query(query+" ORDER BY id", params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tracks []playlistTrack
+	for rows.Next() {
+		var t playlistTrack
+		if err := rows.Scan(&t.title, &t.uploader, &t.duration, &t.path); err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, t)
+	}
+	return tracks, rows.Err()
+}
+
+// playlistPath returns the path to write for a track, either absolute or
+// relative to base, using forward slashes as players expect.
+func playlistPath(path, base string, absolute bool) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if absolute {
+		return filepath.ToSlash(abs), nil
+	}
+	absBase, err := filepath.Abs(base)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(absBase, abs)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// workerPool runs max workers over a buffered jobs channel, which main fills
+// and closes before the workers start. A worker exits once the channel is
+// drained, so none of them sit idle and the pool has nothing to scale down.
+//
+// With maxDownloads, workers stop taking jobs once that many tracks have
+// been downloaded, and the jobs still queued are counted in left. Jobs
+// already running are finished, so the cap can be passed by up to max-1.
+type workerPool struct {
+	jobs         <-chan Job
+	max          int
+	maxDownloads int64
+	downloads    atomic.Int64
+	left         atomic.Int64
+	wg           sync.WaitGroup
+}
+
+func newWorkerPool(jobs <-chan Job, max int, maxDownloads int64) *workerPool {
+	return &workerPool{jobs: jobs, max: max, maxDownloads: maxDownloads}
+}
+
+// downloaded counts a downloaded track towards maxDownloads.
+func (p *workerPool) downloaded() {
+	p.downloads.Add(1)
+}
+
+// run starts workers with work and blocks until they have all exited.
+// Workers are started rampUp apart.
+func (p *workerPool) run(rampUp time.Duration, work func(id int)) {
+	for i := 0; i < p.max; i++ {
+		// staggered start: worker i begins i*rampUp after the first
+		if i > 0 && rampUp > 0 {
+			time.Sleep(rampUp)
+		}
+		p.wg.Add(1)
+		go func(id int) {
+			defer p.wg.Done()
+			work(id)
+		}(i + 1)
+	}
+	p.wg.Wait()
+}
+
+// next returns the next job for a worker. ok is false when the worker should
+// exit: the queue is empty or maxDownloads is reached.
+func (p *workerPool) next() (job Job, ok bool) {
+	if p.maxDownloads > 0 && p.downloads.Load() >= p.maxDownloads {
+		// the queue is closed and filled, so this ends
+		for range p.jobs {
+			p.left.Add(1)
+		}
+		return Job{}, false
+	}
+	job, ok = <-p.jobs
+	return job, ok
+}
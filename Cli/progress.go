@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressWidth is the number of cells in the bar.
+const progressWidth = 30
+
+// progressBar keeps a "[####    ] 12/500" line at the bottom of the
+// terminal for -progress. Log lines are printed above it (see runLog).
+// A nil *progressBar draws nothing.
+type progressBar struct {
+	mu     sync.Mutex
+	out    io.Writer
+	total  int
+	done   int
+	failed int
+	start  time.Time
+}
+
+// bar is the progress bar of the current run, if any.
+var bar *progressBar
+
+func newProgressBar(out io.Writer, total int) *progressBar {
+	return &progressBar{out: out, total: total, start: time.Now()}
+}
+
+// finish counts one job as completed and redraws the bar.
+func (p *progressBar) finish(failed bool) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	if failed {
+		p.failed++
+	}
+	p.draw()
+}
+
+// above prints line above the bar.
+func (p *progressBar) above(line string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// \r and erase-line, so the bar does not stick to the end of the line
+	fmt.Fprintf(p.out, "\r\033[K%s\n", line)
+	p.draw()
+}
+
+// end leaves the final state of the bar on its own line.
+func (p *progressBar) end() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.draw()
+	fmt.Fprintln(p.out)
+}
+
+func (p *progressBar) draw() {
+	filled := progressWidth
+	if p.total > 0 {
+		filled = p.done * progressWidth / p.total
+	}
+	line := fmt.Sprintf("[%s%s] %d/%d", strings.Repeat("#", filled), strings.Repeat(" ", progressWidth-filled), p.done, p.total)
+	if p.failed > 0 {
+		line += fmt.Sprintf(", %d failed", p.failed)
+	}
+	if p.done > 0 && p.done < p.total {
+		left := time.Since(p.start) / time.Duration(p.done) * time.Duration(p.total-p.done)
+		line += fmt.Sprintf(", ~%s left", left.Round(time.Second))
+	}
+	fmt.Fprintf(p.out, "\r\033[K%s", line)
+}
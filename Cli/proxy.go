@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A proxy that fails proxyMaxFailures downloads in a row is left out of the
+// rotation for proxyBadFor.
+const (
+	proxyMaxFailures = 3
+	proxyBadFor      = 10 * time.Minute
+)
+
+// proxyPool hands out proxies from -proxy-list round-robin, skipping the ones
+// currently marked bad. A nil *proxyPool hands out "" (no proxy).
+type proxyPool struct {
+	mu      sync.Mutex
+	proxies []*proxyState
+	next    int
+}
+
+type proxyState struct {
+	url      string
+	fails    int // consecutive failures
+	badUntil time.Time
+}
+
+// loadProxyList reads one proxy URL per line from path. Blank lines and lines
+// starting with # are ignored.
+func loadProxyList(path string) (*proxyPool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p := &proxyPool{}
+	sc := bufio.NewScanner(f)
+	line := 0
+	for sc.Scan() {
+		line++
+		s := strings.TrimSpace(sc.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		if err := validateProxy(s); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		p.proxies = append(p.proxies, &proxyState{url: s})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(p.proxies) == 0 {
+		return nil, fmt.Errorf("%s: no proxies", path)
+	}
+	return p, nil
+}
+
+// proxySchemes are the proxy URL schemes accepted by -proxy and -proxy-list.
+var proxySchemes = map[string]bool{"http": true, "https": true, "socks5": true}
+
+// validateProxy checks that s is a proxy URL yt-dlp understands: an http,
+// https or socks5 scheme and a host.
+func validateProxy(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("invalid proxy %q: %w", redactProxy(s), err)
+	}
+	if !proxySchemes[strings.ToLower(u.Scheme)] || u.Host == "" {
+		return fmt.Errorf("invalid proxy %q, want http://, https:// or socks5://host:port", redactProxy(s))
+	}
+	return nil
+}
+
+// pick returns the next proxy to use. When every proxy is marked bad, the
+// one that becomes usable first is returned rather than none at all.
+func (p *proxyPool) pick() string {
+	if p == nil {
+		return ""
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	var soonest *proxyState
+	for range p.proxies {
+		s := p.proxies[p.next]
+		p.next = (p.next + 1) % len(p.proxies)
+		if !now.Before(s.badUntil) {
+			return s.url
+		}
+		if soonest == nil || s.badUntil.Before(soonest.badUntil) {
+			soonest = s
+		}
+	}
+	return soonest.url
+}
+
+// report records the outcome of a download through proxy.
+func (p *proxyPool) report(proxy string, err error) {
+	if p == nil || proxy == "" {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, s := range p.proxies {
+		if s.url != proxy {
+			continue
+		}
+		if err == nil || !networkFailure(err) {
+			// the proxy got through; any error was about the video
+			s.fails = 0
+			return
+		}
+		s.fails++
+		if s.fails >= proxyMaxFailures {
+			s.fails = 0
+			s.badUntil = time.Now().Add(proxyBadFor)
+			mainLog.Warnf("proxy %s failed %d times in a row, skipping it for %s", redactProxy(proxy), proxyMaxFailures, proxyBadFor)
+		}
+		return
+	}
+}
+
+// networkErrors are what yt-dlp writes to stderr, in lower case, when it
+// could not reach the site, the kind of failure another proxy may avoid.
+var networkErrors = []string{
+	"unable to connect to proxy", "proxyerror", "tunnel connection failed", "http error 407",
+	"connection refused", "connection reset", "timed out", "network is unreachable",
+	"temporary failure in name resolution", "name or service not known", "nodename nor servname",
+}
+
+// networkFailure reports whether err from callYtDlp says the download
+// failed on the way to the site: a -timeout, or yt-dlp exiting with one of
+// networkErrors. Skips, filters and bad output are not the proxy's fault.
+func networkFailure(err error) bool {
+	if errors.Is(err, errDownloadTimeout) {
+		return true
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, e := range networkErrors {
+		if strings.Contains(msg, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactProxy drops any user:password from a proxy URL so it can be logged
+// and stored.
+func redactProxy(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	u.User = nil
+	return u.String()
+}
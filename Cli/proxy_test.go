@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+)
+
+func TestNetworkFailure(t *testing.T) {
+	exitErr := exec.Command("false").Run()
+	if exitErr == nil {
+		t.Fatal("false exited 0")
+	}
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("yt-dlp failed: %w: %s", exitErr, "ERROR: Unable to download webpage: <urlopen error [Errno 111] Connection refused>"), true},
+		{fmt.Errorf("yt-dlp failed: %w: %s", exitErr, "ERROR: [youtube] abc: Read timed out."), true},
+		{fmt.Errorf("yt-dlp failed: %w: %s", exitErr, "ERROR: [youtube] abc: Video unavailable. This video is private"), false},
+		{errDownloadTimeout, true},
+		{fmt.Errorf("%w: view_count > 1000", errFilteredOut), false},
+		{errArchived, false},
+		{errors.New("no .info.json produced by yt-dlp"), false},
+	}
+	for _, tt := range tests {
+		if got := networkFailure(tt.err); got != tt.want {
+			t.Errorf("networkFailure(%q) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+// TestProxyReportIgnoresVideoErrors checks that filtered downloads never
+// bench a proxy, while network errors do.
+func TestProxyReportIgnoresVideoErrors(t *testing.T) {
+	p := &proxyPool{proxies: []*proxyState{{url: "http://a:3128"}, {url: "http://b:3128"}}}
+	for i := 0; i < 2*proxyMaxFailures; i++ {
+		p.report("http://a:3128", errFilteredOut)
+	}
+	if !p.proxies[0].badUntil.IsZero() {
+		t.Error("filtered downloads marked the proxy bad")
+	}
+	for i := 0; i < proxyMaxFailures; i++ {
+		p.report("http://b:3128", errDownloadTimeout)
+	}
+	if p.proxies[1].badUntil.IsZero() {
+		t.Error("timeouts did not mark the proxy bad")
+	}
+}
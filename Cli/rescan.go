@@ -0,0 +1,195 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// lastRescanKey is the meta key holding when the last rescan started.
+const lastRescanKey = "last_rescan"
+
+// scannedFile is a file found by findByStem.
+type scannedFile struct {
+	Path    string
+	ModTime time.Time
+}
+
+// mediaExts are the extensions of the files rescan pairs with an info.json:
+// those of -formats, then the containers -video and -format-id downloads
+// keep. When a stem has several, as with extra -formats, the first one here
+// is taken as the track's file and the others as its extra formats.
+var mediaExts = []string{".mp3", ".opus", ".m4a", ".flac", ".wav", ".mp4", ".mkv", ".webm", ".mov", ".ogg"}
+
+// runRescan rebuilds tracks rows from the media and .info.json files already on
+// disk. Files are paired by their shared stem, as written by callYtDlp.
+// It also runs as `scan`, for importing a folder downloaded without a DB.
+func runRescan(args []string) {
+	fset := flag.NewFlagSet("rescan", flag.ExitOnError)
+	dbPath := dbFlag(fset)
+	mp3Dir := pathFlag(fset, "mp3dir", envMp3Dir, defaultMp3Dir, "directory holding the audio (or -video) files")
+	dataDir := pathFlag(fset, "datadir", envDataDir, defaultDataDir, "directory holding info.json blobs")
+	conflictPolicy := fset.String("conflict-policy", conflictOverwrite, "when a track already exists: overwrite, skip or keep-metadata")
+	backupDB := fset.Bool("backup-db", true, "copy the DB to <db>.bak-<timestamp> before upgrading its schema")
+	since := fset.String("since", "", "only consider pairs with a file modified after this time (RFC3339, YYYY-MM-DD or \"last\" for the previous rescan)")
+	_ = fset.Parse(args)
+
+	if !validConflictPolicy(*conflictPolicy) {
+		fmt.Println("invalid -conflict-policy:", *conflictPolicy)
+		os.Exit(1)
+	}
+
+	started := time.Now()
+	mp3s, err := findByStem(*mp3Dir, mediaExts...)
+	if err != nil {
+		fmt.Println("scan mp3 dir:", err)
+		os.Exit(1)
+	}
+	infos, err := findByStem(*dataDir, ".info.json")
+	if err != nil {
+		fmt.Println("scan data dir:", err)
+		os.Exit(1)
+	}
+
+	db, err := ensureDB(*dbPath, *backupDB)
+	if err != nil {
+		fmt.Println("db error:", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	cutoff, err := parseSince(db, *since)
+	if err != nil {
+		fmt.Println("invalid -since:", err)
+		os.Exit(1)
+	}
+
+	ids := make([]string, 0, len(infos))
+	for id := range infos {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	restored, present, failed, unchanged := 0, 0, 0, 0
+	var unmatched []string
+	for _, id := range ids {
+		infoFile := infos[id]
+		mp3File, ok := mp3s[id]
+		if !ok {
+			if infoFile.ModTime.After(cutoff) {
+				unmatched = append(unmatched, infoFile.Path)
+			}
+			continue
+		}
+		delete(mp3s, id)
+		if !infoFile.ModTime.After(cutoff) && !mp3File.ModTime.After(cutoff) {
+			unchanged++
+			continue
+		}
+
+		info, raw, err := parseInfoJSON(infoFile.Path)
+		if err != nil {
+			fmt.Printf("[rescan] cannot parse %s: %s\n", sanitizeForLog(infoFile.Path), sanitizeForLog(err.Error()))
+			failed++
+			continue
+		}
+		if info.ID == "" {
+			info.ID = id
+		}
+		// rows that already point at this file are left as they are
+		var exists int
+		if db.QueryRow("SELECT 1 FROM tracks WHERE ytdlp_id = ? AND mp3_path = ? AND status = ?", info.ID, mp3File.Path, statusDownloaded).Scan(&exists) == nil {
+			present++
+			continue
+		}
+		if err := upsertTrack(db, *conflictPolicy, Track{Info: info, RawJSON: raw, URL: info.Webpage, Mp3Path: mp3File.Path, Status: statusDownloaded}); err != nil {
+			fmt.Printf("[rescan] db insert failed for %s: %v\n", sanitizeForLog(id), err)
+			failed++
+			continue
+		}
+		restored++
+	}
+	for _, f := range mp3s {
+		if f.ModTime.After(cutoff) {
+			unmatched = append(unmatched, f.Path)
+		}
+	}
+	sort.Strings(unmatched)
+
+	for _, p := range unmatched {
+		fmt.Println("[rescan] no matching pair for", sanitizeForLog(p))
+	}
+	fmt.Printf("[rescan] restored %d tracks, %d already present, %d failed, %d unmatched files", restored, present, failed, len(unmatched))
+	if !cutoff.IsZero() {
+		fmt.Printf(", %d unchanged since %s", unchanged, cutoff.Format(time.RFC3339))
+	}
+	fmt.Println()
+
+	if err := setMeta(db, lastRescanKey, started.Format(time.RFC3339Nano)); err != nil {
+		fmt.Println("[rescan] cannot record scan time:", err)
+	}
+}
+
+// parseSince turns the -since value into a cutoff time. "" means no cutoff
+// and "last" uses the start time recorded by the previous rescan.
+func parseSince(db *sql.DB, since string) (time.Time, error) {
+	switch since {
+	case "":
+		return time.Time{}, nil
+	case "last":
+		last, err := getMeta(db, lastRescanKey)
+		if err != nil || last == "" {
+			// no previous scan: consider everything
+			return time.Time{}, err
+		}
+		return time.Parse(time.RFC3339Nano, last)
+	}
+	if t, err := time.Parse(time.RFC3339, since); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", since, time.Local)
+}
+
+// findByStem walks dir and maps each file ending in one of suffixes by its
+// name without that suffix. Of files sharing a stem, the one whose suffix
+// comes first wins. A missing dir yields an empty map.
+func findByStem(dir string, suffixes ...string) (map[string]scannedFile, error) {
+	found := make(map[string]scannedFile)
+	rank := make(map[string]int)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && p == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		for i, suffix := range suffixes {
+			stem, ok := strings.CutSuffix(d.Name(), suffix)
+			if !ok {
+				continue
+			}
+			if r, seen := rank[stem]; seen && r <= i {
+				return nil
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			found[stem] = scannedFile{Path: p, ModTime: fi.ModTime()}
+			rank[stem] = i
+			return nil
+		}
+		return nil
+	})
+	return found, err
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// trackResult is the -write-result sidecar of a finished download: what this
+// tool did with it, as opposed to yt-dlp's info.json.
+type trackResult struct {
+	ID              string            `json:"id"`
+	URL             string            `json:"url"`
+	Title           string            `json:"title"`
+	Status          Status            `json:"status"`
+	Error           string            `json:"error,omitempty"`
+	DurationSeconds float64           `json:"duration_seconds"`
+	Path            string            `json:"path"`
+	InfoJSON        string            `json:"info_json"`
+	Extra           map[string]string `json:"extra,omitempty"`  // format -> path
+	Copies          []string          `json:"copies,omitempty"` // -dest copies
+	SHA256          string            `json:"sha256,omitempty"`
+	LUFS            *float64          `json:"lufs,omitempty"`
+	FinishedAt      string            `json:"finished_at"`
+}
+
+// resultPath is where the sidecar of the download with info.json infoPath
+// goes: next to it, under the same name.
+func resultPath(infoPath string) string {
+	return strings.TrimSuffix(infoPath, ".info.json") + ".result.json"
+}
+
+// writeResult writes the sidecar for track, downloaded as dl. Like the audio
+// files, it appears in one step.
+func writeResult(dl Download, track Track, replicas map[string]string) error {
+	res := trackResult{
+		ID:              track.Info.ID,
+		URL:             track.URL,
+		Title:           track.Info.Title,
+		Status:          track.Status,
+		Error:           track.ErrText,
+		DurationSeconds: track.Info.Duration,
+		Path:            track.Mp3Path,
+		InfoJSON:        dl.InfoPath,
+		Extra:           dl.Extra,
+		SHA256:          track.SHA256,
+		LUFS:            track.LUFS,
+		FinishedAt:      time.Now().UTC().Format(time.RFC3339),
+	}
+	for path := range replicas {
+		res.Copies = append(res.Copies, path)
+	}
+	data, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return err
+	}
+	dst := resultPath(dl.InfoPath)
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
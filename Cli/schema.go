@@ -0,0 +1,118 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runSchemaSQL prints the CREATE statements of an existing database as
+// stored in sqlite_master, so the output reflects any migrations applied.
+// The database is opened as-is and never migrated by this command.
+func runSchemaSQL(args []string) {
+	fset := flag.NewFlagSet("schema-sql", flag.ExitOnError)
+	dbPath := dbFlag(fset)
+	_ = fset.Parse(args)
+
+	if _, err := os.Stat(*dbPath); err != nil {
+		fmt.Println("db error:", err)
+		os.Exit(1)
+	}
+	dsn, err := sqliteURI(*dbPath, "")
+	if err != nil {
+		fmt.Println("db error:", err)
+		os.Exit(1)
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		fmt.Println("db error:", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	// rowid order is creation order, so tables come before their indexes
+	rows, err := db.Query(`SELECT sql FROM sqlite_master
+		WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%'
+		ORDER BY rowid`)
+	if err != nil {
+		fmt.Println("db error:", err)
+		os.Exit(1)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var stmt string
+		if err := rows.Scan(&stmt); err != nil {
+			fmt.Println("db error:", err)
+			os.Exit(1)
+		}
+		fmt.Println(stmt + ";")
+	}
+	if err := rows.Err(); err != nil {
+		fmt.Println("db error:", err)
+		os.Exit(1)
+	}
+}
+
+// openExistingDB opens dbPath read-only without creating or migrating it.
+// It returns a nil *sql.DB when there is no database yet.
+func openExistingDB(dbPath string) (*sql.DB, error) {
+	if _, err := os.Stat(dbPath); errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	dsn, err := sqliteURI(dbPath, "mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// sqliteURI returns the file: URI of the DB at dbPath with query appended.
+// Given as a plain path, a ? or # in dbPath would be taken for the start of
+// the query.
+func sqliteURI(dbPath, query string) (string, error) {
+	abs, err := filepath.Abs(dbPath)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.ToSlash(abs)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path // C:/... on Windows
+	}
+	return (&url.URL{Scheme: "file", Path: path, RawQuery: query}).String(), nil
+}
+
+// hasTable reports whether the DB has a table of that name. A DB opened
+// by openExistingDB is not migrated and can lack newer tables.
+func hasTable(db *sql.DB, name string) (bool, error) {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// hasColumn reports whether table has column, which an unmigrated DB can
+// lack.
+func hasColumn(db *sql.DB, table, column string) (bool, error) {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_xinfo(?) WHERE name = ?", table, column).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
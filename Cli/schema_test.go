@@ -0,0 +1,94 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestOpenExistingDBSpecialPath opens a DB whose path holds characters
+// that mean something in a file: URI.
+func TestOpenExistingDBSpecialPath(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "what?#100%25 music")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "tracks.db")
+	db, err := ensureDB(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upsertTrack(db, conflictOverwrite, testTrack(0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	ro, err := openExistingDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ro == nil {
+		t.Fatal("openExistingDB found no DB")
+	}
+	defer ro.Close()
+	var n int
+	if err := ro.QueryRow("SELECT COUNT(*) FROM tracks").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("%d rows, want 1", n)
+	}
+	if _, err := ro.Exec("DELETE FROM tracks"); err == nil {
+		t.Error("a read-only DB accepted a write")
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(dir)); len(entries) != 1 {
+		t.Errorf("opening created files next to the DB dir: %v", entries)
+	}
+}
+
+// TestDryRunChecksOnOldSchema runs the skip checks of a dry run against a
+// DB from before the section column and track_attempts table.
+func TestDryRunChecksOnOldSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+	old, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Exec(`CREATE TABLE tracks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		ytdlp_id TEXT UNIQUE,
+		url TEXT NOT NULL,
+		title TEXT,
+		uploader TEXT,
+		duration_seconds INTEGER,
+		mp3_path TEXT,
+		info_json TEXT,
+		downloaded_at TEXT DEFAULT (datetime('now')),
+		status TEXT DEFAULT 'downloaded',
+		error_text TEXT
+	);
+	INSERT INTO tracks (url, status) VALUES ('https://example.com/done', 'downloaded');
+	INSERT INTO tracks (url, status) VALUES ('https://example.com/broken', 'failed');`)
+	old.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := openExistingDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	done, err := alreadyDownloaded(db, "https://example.com/done", "", false)
+	if err != nil || !done {
+		t.Errorf("alreadyDownloaded = %v, %v; want true", done, err)
+	}
+	if done, err := alreadyDownloaded(db, "https://example.com/done", "*1:00-2:00", false); err != nil || done {
+		t.Errorf("alreadyDownloaded for a section = %v, %v; want false", done, err)
+	}
+	failed, err := failedRecently(db, "https://example.com/broken", "", 0)
+	if err != nil || !failed {
+		t.Errorf("failedRecently = %v, %v; want true", failed, err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// likeEscaper escapes the LIKE wildcards in a search term.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// runSearch lists the tracks whose title, uploader or tags contain every
+// word of the query, ignoring case.
+func runSearch(args []string) {
+	fset := flag.NewFlagSet("search", flag.ExitOnError)
+	dbPath := dbFlag(fset)
+	status := statusFlag(fset, "", "only search tracks with this status, e.g. downloaded")
+	asJSON := fset.Bool("json", false, "print a JSON array instead of a table")
+	var tags stringList
+	fset.Var(&tags, "tag", "only search tracks with this tag; repeat to require several")
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "usage: search [flags] word ...\n")
+		fset.PrintDefaults()
+	}
+	_ = fset.Parse(args)
+	terms := strings.Fields(strings.Join(fset.Args(), " "))
+	if len(terms) == 0 {
+		fset.Usage()
+		os.Exit(2)
+	}
+
+	db, err := openExistingDB(*dbPath)
+	if err != nil {
+		fmt.Println("db error:", err)
+		os.Exit(1)
+	}
+	tracks := []listedTrack{}
+	if db != nil {
+		defer db.Close()
+		if len(tags) > 0 {
+			if err := checkTagsTable(db); err != nil {
+				fmt.Println("db error:", err)
+				os.Exit(1)
+			}
+		}
+		took, err := downloadMSColumn(db)
+		if err != nil {
+			fmt.Println("db error:", err)
+			os.Exit(1)
+		}
+		// a DB from before the tags table is still searched by title and uploader
+		haveTags, err := hasTable(db, "tags")
+		if err != nil {
+			fmt.Println("db error:", err)
+			os.Exit(1)
+		}
+		query := `SELECT COALESCE(ytdlp_id, ''), COALESCE(url, ''), COALESCE(title, ''), COALESCE(uploader, ''), COALESCE(duration_seconds, 0), COALESCE(status, ''), ` + took + ` FROM tracks
+			WHERE 1 = 1`
+		var params []any
+		for _, term := range terms {
+			like := "%" + likeEscaper.Replace(term) + "%"
+			query += ` AND (title LIKE ? ESCAPE '\' OR uploader LIKE ? ESCAPE '\'`
+			params = append(params, like, like)
+			if haveTags {
+				query += ` OR id IN (SELECT track_id FROM tags WHERE tag LIKE ? ESCAPE '\')`
+				params = append(params, like)
+			}
+			query += ")"
+		}
+		if *status != "" {
+			query += " AND status = ?"
+			params = append(params, *status)
+		}
+		query, params = withTags(query, params, tags)
+		query += " ORDER BY id"
+		rows, err := db.Query(query, params...)
+		if err != nil {
+			fmt.Println("db error:", err)
+			os.Exit(1)
+		}
+		for rows.Next() {
+			var t listedTrack
+			if err := rows.Scan(&t.ID, &t.URL, &t.Title, &t.Uploader, &t.Duration, &t.Status, &t.DownloadMS); err != nil {
+				fmt.Println("db error:", err)
+				os.Exit(1)
+			}
+			tracks = append(tracks, t)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			fmt.Println("db error:", err)
+			os.Exit(1)
+		}
+	}
+	printTracks(tracks, *asJSON)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// sniffLen is how much of a file sniffAudio looks at.
+const sniffLen = 12
+
+// sniffAudio checks the first bytes of the file at path for the signature of
+// an audio format or media container we can produce or keep, and returns an
+// error naming what it found otherwise. It catches things like an HTML error
+// page saved under an audio extension; it does not decode the stream.
+func sniffAudio(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			return fmt.Errorf("%s is empty", filepath.Base(path))
+		}
+		return err
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte("ID3")): // mp3 with ID3v2 tags
+	case len(head) >= 2 && head[0] == 0xFF && head[1]&0xE0 == 0xE0: // bare mpeg audio frame, also ADTS aac
+	case bytes.HasPrefix(head, []byte("OggS")): // opus, vorbis
+	case bytes.HasPrefix(head, []byte("fLaC")):
+	case bytes.HasPrefix(head, []byte("RIFF")) && len(head) >= 12 && string(head[8:12]) == "WAVE":
+	case len(head) >= 8 && string(head[4:8]) == "ftyp": // m4a, mp4
+	case bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3}): // webm, mkv
+	default:
+		return fmt.Errorf("%s is not audio (starts with %q)", filepath.Base(path), head)
+	}
+	return nil
+}
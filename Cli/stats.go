@@ -0,0 +1,230 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// statusCount and uploaderCount are rows of `stats`.
+type statusCount struct {
+	Status string `json:"status"`
+	Tracks int    `json:"tracks"`
+}
+
+type uploaderCount struct {
+	Uploader string `json:"uploader"`
+	Tracks   int    `json:"tracks"`
+}
+
+// hostTiming is the average download time of a site's tracks.
+type hostTiming struct {
+	Host          string `json:"host"`
+	Tracks        int    `json:"tracks"`
+	AvgDownloadMS int64  `json:"avg_download_ms"`
+}
+
+// libraryStats is the output of `stats -json`. Duration, bytes, uploaders
+// and timings cover downloaded tracks only; Bytes is the size of their
+// primary files still on disk, and DownloadMS the time yt-dlp took for those
+// with a recorded download_ms.
+type libraryStats struct {
+	Tracks          int             `json:"tracks"`
+	ByStatus        []statusCount   `json:"by_status"`
+	DurationSeconds int64           `json:"duration_seconds"`
+	Bytes           int64           `json:"bytes"`
+	MissingFiles    int             `json:"missing_files"`
+	TopUploaders    []uploaderCount `json:"top_uploaders"`
+	DownloadMS      int64           `json:"download_ms"`
+	SlowestHosts    []hostTiming    `json:"slowest_hosts"`
+}
+
+// runLibraryStats prints a summary of the library in the DB.
+func runLibraryStats(args []string) {
+	fset := flag.NewFlagSet("stats", flag.ExitOnError)
+	dbPath := dbFlag(fset)
+	asJSON := fset.Bool("json", false, "print a JSON object instead of a table")
+	_ = fset.Parse(args)
+
+	db, err := openExistingDB(*dbPath)
+	if err != nil {
+		fmt.Println("db error:", err)
+		os.Exit(1)
+	}
+	st := libraryStats{ByStatus: []statusCount{}, TopUploaders: []uploaderCount{}, SlowestHosts: []hostTiming{}}
+	if db != nil {
+		defer db.Close()
+		if err := collectStats(db, &st); err != nil {
+			fmt.Println("db error:", err)
+			os.Exit(1)
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(st); err != nil {
+			fmt.Fprintln(os.Stderr, "cannot write JSON:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "tracks\t%d\n", st.Tracks)
+	for _, s := range st.ByStatus {
+		fmt.Fprintf(tw, "  %s\t%d\n", sanitizeForLog(s.Status), s.Tracks)
+	}
+	d := st.DurationSeconds
+	fmt.Fprintf(tw, "duration\t%02d:%02d:%02d\n", d/3600, d/60%60, d%60)
+	size := formatBytes(st.Bytes)
+	if st.MissingFiles > 0 {
+		size += fmt.Sprintf(" (%d missing)", st.MissingFiles)
+	}
+	fmt.Fprintf(tw, "on disk\t%s\n", size)
+	if len(st.TopUploaders) > 0 {
+		fmt.Fprintln(tw, "top uploaders")
+		for _, u := range st.TopUploaders {
+			fmt.Fprintf(tw, "  %s\t%d\n", sanitizeForLog(u.Uploader), u.Tracks)
+		}
+	}
+	if st.DownloadMS > 0 {
+		fmt.Fprintf(tw, "download time\t%s\n", msDuration(st.DownloadMS))
+		fmt.Fprintln(tw, "slowest sites (average)")
+		for _, h := range st.SlowestHosts {
+			fmt.Fprintf(tw, "  %s\t%s over %d\n", sanitizeForLog(h.Host), msDuration(h.AvgDownloadMS), h.Tracks)
+		}
+	}
+	_ = tw.Flush()
+}
+
+// statsTopUploaders and statsSlowestHosts are how many uploaders and sites
+// `stats` lists.
+const (
+	statsTopUploaders = 5
+	statsSlowestHosts = 5
+)
+
+// msDuration renders a download_ms value, to a tenth of a second.
+func msDuration(ms int64) string {
+	return (time.Duration(ms) * time.Millisecond).Round(100 * time.Millisecond).String()
+}
+
+func collectStats(db *sql.DB, st *libraryStats) error {
+	rows, err := db.Query("SELECT COALESCE(status, ''), COUNT(*) FROM tracks GROUP BY 1 ORDER BY 2 DESC, 1")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var s statusCount
+		if err := rows.Scan(&s.Status, &s.Tracks); err != nil {
+			rows.Close()
+			return err
+		}
+		st.ByStatus = append(st.ByStatus, s)
+		st.Tracks += s.Tracks
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	err = db.QueryRow("SELECT CAST(COALESCE(SUM(duration_seconds), 0) AS INTEGER) FROM tracks WHERE status = ?", statusDownloaded).Scan(&st.DurationSeconds)
+	if err != nil {
+		return err
+	}
+
+	rows, err = db.Query("SELECT uploader, COUNT(*) FROM tracks WHERE status = ? AND COALESCE(uploader, '') <> '' GROUP BY 1 ORDER BY 2 DESC, 1 LIMIT ?", statusDownloaded, statsTopUploaders)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var u uploaderCount
+		if err := rows.Scan(&u.Uploader, &u.Tracks); err != nil {
+			rows.Close()
+			return err
+		}
+		st.TopUploaders = append(st.TopUploaders, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := collectTimings(db, st); err != nil {
+		return err
+	}
+
+	// sizes are not stored, so they come from the files themselves
+	rows, err = db.Query("SELECT COALESCE(mp3_path, '') FROM tracks WHERE status = ?", statusDownloaded)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return err
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			st.MissingFiles++
+			continue
+		}
+		st.Bytes += fi.Size()
+	}
+	return rows.Err()
+}
+
+// collectTimings adds up download_ms per site, grouping URLs the way
+// -per-host does. A DB from before download_ms has no timings.
+func collectTimings(db *sql.DB, st *libraryStats) error {
+	if col, err := downloadMSColumn(db); err != nil || col == "NULL" {
+		return err
+	}
+	rows, err := db.Query("SELECT COALESCE(url, ''), download_ms FROM tracks WHERE status = ? AND download_ms IS NOT NULL", statusDownloaded)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	byHost := make(map[string]*hostTiming)
+	for rows.Next() {
+		var u string
+		var ms int64
+		if err := rows.Scan(&u, &ms); err != nil {
+			return err
+		}
+		st.DownloadMS += ms
+		host := siteHost(u)
+		h := byHost[host]
+		if h == nil {
+			h = &hostTiming{Host: host}
+			byHost[host] = h
+		}
+		h.Tracks++
+		// the sum until the averages are taken below
+		h.AvgDownloadMS += ms
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, h := range byHost {
+		h.AvgDownloadMS /= int64(h.Tracks)
+		st.SlowestHosts = append(st.SlowestHosts, *h)
+	}
+	sort.Slice(st.SlowestHosts, func(i, j int) bool {
+		a, b := st.SlowestHosts[i], st.SlowestHosts[j]
+		if a.AvgDownloadMS != b.AvgDownloadMS {
+			return a.AvgDownloadMS > b.AvgDownloadMS
+		}
+		return a.Host < b.Host
+	})
+	if len(st.SlowestHosts) > statsSlowestHosts {
+		st.SlowestHosts = st.SlowestHosts[:statsSlowestHosts]
+	}
+	return nil
+}
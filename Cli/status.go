@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// Status is the status column of a tracks row.
+type Status string
+
+const (
+	statusDownloaded Status = "downloaded"
+	statusFailed     Status = "failed"
+	// statusDuplicate has the same audio as the row in duplicate_of and no
+	// file of its own (-dedup-content)
+	statusDuplicate Status = "duplicate"
+	// statusPartialReplication is downloaded but missing from some -dest
+	statusPartialReplication Status = "partial-replication"
+	statusInvalidURL         Status = "invalid-url"
+
+	statusSkippedLive     Status = "skipped-live"
+	statusSkippedUser     Status = "skipped-user"
+	statusSkippedUploader Status = "skipped-uploader"
+	statusSkippedFilter   Status = "skipped-filter"
+	statusSkippedExcluded Status = "skipped-excluded"
+	statusSkippedArchive  Status = "skipped-archive"
+)
+
+// statuses are the statuses other than skips, which the schema lists one
+// by one. Every skipped-* status is allowed, so new kinds of skip need no
+// schema change; a new status here needs a migration updating the
+// triggers, and cannot reach the CHECK constraint of existing databases.
+var statuses = []Status{statusDownloaded, statusFailed, statusDuplicate, statusPartialReplication, statusInvalidURL}
+
+// skippedPrefix starts every status of a URL that was deliberately not
+// downloaded.
+const skippedPrefix = "skipped-"
+
+// statusCheck is the SQL condition column, a status, must meet. It is a
+// CHECK constraint of tracks in new databases and checked by triggers in
+// older ones, where SQLite cannot add a constraint.
+func statusCheck(column string) string {
+	quoted := make([]string, len(statuses))
+	for i, s := range statuses {
+		quoted[i] = "'" + string(s) + "'"
+	}
+	return fmt.Sprintf("%[1]s IN (%[2]s) OR %[1]s GLOB '%[3]s*'", column, strings.Join(quoted, ", "), skippedPrefix)
+}
+
+// addStatusTriggers enforces statusCheck on the tracks of a database
+// created before it was a constraint.
+func addStatusTriggers(tx *sql.Tx) error {
+	for name, event := range map[string]string{"tracks_status_insert": "INSERT", "tracks_status_update": "UPDATE OF status"} {
+		_, err := tx.Exec(fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS %s BEFORE %s ON tracks
+			WHEN NOT (%s)
+			BEGIN SELECT RAISE(ABORT, 'CHECK constraint failed: status'); END`, name, event, statusCheck("NEW.status")))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseStatus checks a -status flag, so a typo is an error rather than an
+// empty result.
+func parseStatus(s string) (Status, error) {
+	st := Status(strings.TrimSpace(s))
+	if strings.HasPrefix(string(st), skippedPrefix) && len(st) > len(skippedPrefix) {
+		return st, nil
+	}
+	for _, known := range statuses {
+		if st == known {
+			return st, nil
+		}
+	}
+	return "", fmt.Errorf("unknown status %q, want one of %s or skipped-*", s, strings.Join(statusNames(), ", "))
+}
+
+func statusNames() []string {
+	names := make([]string, len(statuses))
+	for i, s := range statuses {
+		names[i] = string(s)
+	}
+	return names
+}
+
+// statusValue is a flag.Value for the -status filter of the commands, which
+// rejects unknown statuses.
+type statusValue struct{ s *Status }
+
+func (v statusValue) String() string {
+	if v.s == nil {
+		return ""
+	}
+	return string(*v.s)
+}
+
+func (v statusValue) Set(s string) error {
+	st, err := parseStatus(s)
+	if err != nil {
+		return err
+	}
+	*v.s = st
+	return nil
+}
+
+// statusFlag defines a -status flag on fset with default def ("" for any).
+func statusFlag(fset *flag.FlagSet, def Status, usage string) *Status {
+	s := new(Status)
+	*s = def
+	fset.Var(statusValue{s}, "status", usage)
+	return s
+}
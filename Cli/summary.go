@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// runStats counts the outcome of every URL in a run, per worker. Worker 0
+// stands for URLs main skipped before queueing them. A nil *runStats counts
+// nothing.
+type runStats struct {
+	mu      sync.Mutex
+	start   time.Time
+	workers map[int]*workerStats
+}
+
+// outcomeCounts are the outcomes of a set of URLs. Bytes is the size of
+// the primary files downloaded.
+type outcomeCounts struct {
+	Downloaded int   `json:"downloaded"`
+	Skipped    int   `json:"skipped"`
+	Failed     int   `json:"failed"`
+	Bytes      int64 `json:"bytes"`
+}
+
+// workerStats are the counts of one worker.
+type workerStats struct {
+	Worker int `json:"worker"`
+	outcomeCounts
+}
+
+func newRunStats() *runStats {
+	return &runStats{start: time.Now(), workers: make(map[int]*workerStats)}
+}
+
+func (s *runStats) get(worker int) *workerStats {
+	w := s.workers[worker]
+	if w == nil {
+		w = &workerStats{Worker: worker}
+		s.workers[worker] = w
+	}
+	return w
+}
+
+// record counts a saved track by its status.
+func (s *runStats) record(worker int, t Track) {
+	if s == nil {
+		return
+	}
+	var size int64
+	if t.Mp3Path != "" {
+		if fi, err := os.Stat(t.Mp3Path); err == nil {
+			size = fi.Size()
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := s.get(worker)
+	switch {
+	case t.Status == statusDownloaded || t.Status == statusPartialReplication:
+		w.Downloaded++
+		w.Bytes += size
+	case t.Status == statusFailed || t.Status == statusInvalidURL:
+		w.Failed++
+	default: // skipped-*, duplicate
+		w.Skipped++
+	}
+}
+
+// skipped counts a URL that was skipped without saving a row.
+func (s *runStats) skipped(worker int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.get(worker).Skipped++
+}
+
+// runSummary is the end-of-run report, as printed by -json.
+type runSummary struct {
+	Workers        []workerStats `json:"workers"`
+	Total          outcomeCounts `json:"total"`
+	ElapsedSeconds float64       `json:"elapsed_seconds"`
+}
+
+func (s *runStats) summary() runSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sum := runSummary{Workers: []workerStats{}, ElapsedSeconds: time.Since(s.start).Seconds()}
+	for _, w := range s.workers {
+		sum.Workers = append(sum.Workers, *w)
+		sum.Total.Downloaded += w.Downloaded
+		sum.Total.Skipped += w.Skipped
+		sum.Total.Failed += w.Failed
+		sum.Total.Bytes += w.Bytes
+	}
+	sort.Slice(sum.Workers, func(i, j int) bool { return sum.Workers[i].Worker < sum.Workers[j].Worker })
+	return sum
+}
+
+// print writes the summary to w as a table, or as a single JSON object.
+func (s *runStats) print(w io.Writer, asJSON bool) error {
+	sum := s.summary()
+	if asJSON {
+		return json.NewEncoder(w).Encode(sum)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "WORKER\tDOWNLOADED\tSKIPPED\tFAILED\tBYTES\t")
+	row := func(name string, c outcomeCounts) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t\n", name, c.Downloaded, c.Skipped, c.Failed, formatBytes(c.Bytes))
+	}
+	for _, c := range sum.Workers {
+		name := fmt.Sprint(c.Worker)
+		if c.Worker == 0 {
+			name = "main"
+		}
+		row(name, c.outcomeCounts)
+	}
+	row("total", sum.Total)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "elapsed %s\n", time.Duration(sum.ElapsedSeconds*float64(time.Second)).Round(time.Second))
+	return err
+}
+
+// formatBytes renders n with a binary unit, e.g. 3.2 MiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), strings.ToUpper("kmgtpe")[exp])
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+)
+
+// stderrTail is an io.Writer that keeps the last max bytes written to it,
+// so the end of a command's stderr can be attached to its error.
+type stderrTail struct {
+	max     int
+	buf     []byte
+	trimmed bool // whether earlier output was dropped
+}
+
+func newStderrTail(max int) *stderrTail {
+	return &stderrTail{max: max}
+}
+
+func (t *stderrTail) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.max {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-t.max:]...)
+		t.trimmed = true
+	}
+	return len(p), nil
+}
+
+// String returns the kept output as its non-blank lines joined by newlines.
+// A line cut in half by the size limit is left out, and progress lines that
+// overwrite themselves with \r only keep their last state, so the result is
+// at most max bytes of readable text.
+func (t *stderrTail) String() string {
+	chunks := bytes.Split(t.buf, []byte("\n"))
+	if t.trimmed && len(chunks) > 1 {
+		chunks = chunks[1:]
+	}
+	var lines []string
+	for _, l := range chunks {
+		if i := bytes.LastIndexByte(l, '\r'); i >= 0 {
+			l = l[i+1:]
+		}
+		if s := strings.TrimSpace(string(l)); s != "" {
+			lines = append(lines, s)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
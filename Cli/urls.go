@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// canonicalizers rewrite the links of a known site to the one form the tool
+// stores and compares, keyed by host without "www."/"m.". Each returns ""
+// for links it does not know, such as playlists, which are then kept as
+// they are. Add an entry to teach the tool another site's share links.
+var canonicalizers = map[string]func(u *url.URL) string{
+	"youtu.be":          youtuBe,
+	"youtube.com":       youtubeVideo,
+	"music.youtube.com": youtubeVideo,
+}
+
+// youtubeWatch is the canonical link of every form of a YouTube video link.
+const youtubeWatch = "https://www.youtube.com/watch?v="
+
+// youtuBe canonicalizes youtu.be/<id> share links.
+func youtuBe(u *url.URL) string {
+	if id := strings.Trim(u.Path, "/"); id != "" && !strings.Contains(id, "/") {
+		return youtubeWatch + id
+	}
+	return ""
+}
+
+// youtubeVideo canonicalizes watch?v=<id> links, dropping &list=, &t= and
+// tracking parameters, and /shorts/, /embed/ and /live/ links.
+func youtubeVideo(u *url.URL) string {
+	if id := u.Query().Get("v"); u.Path == "/watch" && id != "" {
+		return youtubeWatch + id
+	}
+	for _, prefix := range []string{"/shorts/", "/embed/", "/live/"} {
+		if id, ok := strings.CutPrefix(u.Path, prefix); ok {
+			if id = strings.Trim(id, "/"); id != "" && !strings.Contains(id, "/") {
+				return youtubeWatch + id
+			}
+		}
+	}
+	return ""
+}
+
+// bareHost is the lower-cased host of u without "www."/"m." and the port.
+func bareHost(u *url.URL) string {
+	host := strings.ToLower(u.Hostname())
+	host = strings.TrimPrefix(host, "www.")
+	return strings.TrimPrefix(host, "m.")
+}
+
+// canonicalURL returns raw in the canonical form its site has in
+// canonicalizers, so shared links of the same video are queued and stored
+// once. Other URLs are only trimmed.
+func canonicalURL(raw string) string {
+	raw = strings.TrimSpace(raw)
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return raw
+	}
+	if c := canonicalizers[bareHost(u)]; c != nil {
+		if s := c(u); s != "" {
+			return s
+		}
+	}
+	return raw
+}
+
+// normalizeURL returns a looser form of raw than canonicalURL, for comparing
+// URLs: the canonical form for known sites, else lower-case scheme and host
+// without "www."/"m.", no fragment or trailing slash. Input that does not
+// parse is only trimmed.
+func normalizeURL(raw string) string {
+	raw = strings.TrimSpace(raw)
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return raw
+	}
+	host := bareHost(u)
+	if c := canonicalizers[host]; c != nil {
+		if s := c(u); s != "" {
+			return s
+		}
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = host
+	if port := u.Port(); port != "" {
+		u.Host += ":" + port
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+	return u.String()
+}
+
+// checkURL reports why raw is not a URL to hand to yt-dlp: it must parse
+// and have an http or https scheme and a host. Typos and relative paths in
+// the input fail here rather than slowly in yt-dlp.
+func checkURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return errors.New("does not parse")
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+	case "":
+		return errors.New("not a URL, it has no http:// or https://")
+	default:
+		return fmt.Errorf("want an http or https URL, got scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return errors.New("has no host")
+	}
+	return nil
+}
+
+// readExcludeFile reads the URLs of an -exclude-file, one per line or as the
+// first column of a CSV, and returns them normalized. Blank lines and lines
+// starting with # are ignored.
+func readExcludeFile(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	excluded := make(map[string]bool)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		first, _, _ := strings.Cut(line, ",")
+		excluded[normalizeURL(strings.Trim(first, `"`))] = true
+	}
+	return excluded, sc.Err()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// runVerify checks that the file of every downloaded track still exists and
+// matches its stored sha256. With -fix, the rows of missing and corrupt
+// files are marked failed so retry-failed downloads them again.
+func runVerify(args []string) {
+	fset := flag.NewFlagSet("verify", flag.ExitOnError)
+	dbPath := dbFlag(fset)
+	fix := fset.Bool("fix", false, "mark the rows of missing or corrupt files as failed")
+	backupDB := fset.Bool("backup-db", true, "copy the DB to <db>.bak-<timestamp> before upgrading its schema")
+	_ = fset.Parse(args)
+
+	if _, err := os.Stat(*dbPath); err != nil {
+		fmt.Println("db error:", err)
+		os.Exit(1)
+	}
+	db, err := ensureDB(*dbPath, *backupDB)
+	if err != nil {
+		fmt.Println("db error:", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	type stored struct {
+		id         int64
+		path, hash string
+	}
+	rows, err := db.Query("SELECT id, COALESCE(mp3_path, ''), COALESCE(sha256, '') FROM tracks WHERE status = ? ORDER BY id", statusDownloaded)
+	if err != nil {
+		fmt.Println("db error:", err)
+		os.Exit(1)
+	}
+	var tracks []stored
+	for rows.Next() {
+		var t stored
+		if err := rows.Scan(&t.id, &t.path, &t.hash); err != nil {
+			fmt.Println("db error:", err)
+			os.Exit(1)
+		}
+		tracks = append(tracks, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		fmt.Println("db error:", err)
+		os.Exit(1)
+	}
+
+	ok, unhashed, missing, corrupt := 0, 0, 0, 0
+	for _, t := range tracks {
+		var problem string
+		sum, _, err := hashFile(t.path)
+		switch {
+		case t.path == "" || errors.Is(err, fs.ErrNotExist):
+			problem = "missing file"
+			missing++
+		case err != nil:
+			problem = "unreadable file: " + err.Error()
+			corrupt++
+		case t.hash == "":
+			// downloaded with -no-hash or before hashes were stored
+			unhashed++
+			continue
+		case sum != t.hash:
+			problem = "sha256 mismatch"
+			corrupt++
+		default:
+			ok++
+			continue
+		}
+		fmt.Printf("[verify] %s: %s\n", problem, sanitizeForLog(t.path))
+		if *fix {
+			if _, err := db.Exec("UPDATE tracks SET status = ?, error_text = ? WHERE id = ?", statusFailed, "verify:"+problem, t.id); err != nil {
+				fmt.Println("db error:", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	fmt.Printf("[verify] checked %d tracks: %d ok, %d without hash, %d missing, %d corrupt\n", len(tracks), ok, unhashed, missing, corrupt)
+	if missing+corrupt > 0 {
+		if *fix {
+			fmt.Println("[verify] marked them failed; run retry-failed to download them again")
+		}
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// writeWatchdog reports database writes that have been running longer than
+// a threshold, e.g. a worker stuck waiting on an SQLite lock, so a stalled
+// writer shows up in the log instead of silently holding up downloads.
+// Stalls are only reported, never acted on: a write stuck inside SQLite
+// cannot be interrupted, so starting another writer would not free it.
+// A nil *writeWatchdog just runs the writes.
+type writeWatchdog struct {
+	threshold time.Duration
+	mu        sync.Mutex
+	nextID    int
+	inflight  map[int]time.Time // write id -> start
+	reported  map[int]bool
+}
+
+func newWriteWatchdog(threshold time.Duration) *writeWatchdog {
+	return &writeWatchdog{
+		threshold: threshold,
+		inflight:  make(map[int]time.Time),
+		reported:  make(map[int]bool),
+	}
+}
+
+// watch runs write while tracking how long it takes.
+func (w *writeWatchdog) watch(write func() error) error {
+	if w == nil {
+		return write()
+	}
+	w.mu.Lock()
+	w.nextID++
+	id := w.nextID
+	w.inflight[id] = time.Now()
+	w.mu.Unlock()
+
+	err := write()
+
+	w.mu.Lock()
+	if w.reported[id] {
+		dbLog.Infof("stalled write finished after %s", time.Since(w.inflight[id]).Round(time.Second))
+	}
+	delete(w.inflight, id)
+	delete(w.reported, id)
+	w.mu.Unlock()
+	return err
+}
+
+// run checks for stalled writes until stop is closed. Each stalled write is
+// reported once when it crosses the threshold and again when it finishes.
+func (w *writeWatchdog) run(stop <-chan struct{}) {
+	if w == nil {
+		return
+	}
+	tick := time.NewTicker(w.threshold / 2)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-tick.C:
+			w.mu.Lock()
+			for id, started := range w.inflight {
+				if !w.reported[id] && now.Sub(started) >= w.threshold {
+					w.reported[id] = true
+					dbLog.Warnf("write stalled for %s (%d writes in flight); is another process holding the database?",
+						now.Sub(started).Round(time.Second), len(w.inflight))
+				}
+			}
+			w.mu.Unlock()
+		}
+	}
+}
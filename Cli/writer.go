@@ -0,0 +1,50 @@
+package main
+
+import "database/sql"
+
+// dbWriter owns the database writes of a download run. Workers hand every
+// write to it over a channel and a single goroutine applies them one after
+// another, so workers never compete for SQLite's write lock; reads such as
+// the skip checks still use the shared *sql.DB.
+//
+// A write returns once it is applied, which keeps a worker's own writes in
+// order and means a job is in the DB before the checkpoint moves past it.
+type dbWriter struct {
+	db   *sql.DB
+	wd   *writeWatchdog
+	reqs chan writeReq
+	done chan struct{}
+}
+
+type writeReq struct {
+	write  func(db *sql.DB) error
+	result chan<- error
+}
+
+// newDBWriter starts the writer goroutine. Stop it with close once every
+// worker has finished.
+func newDBWriter(db *sql.DB, wd *writeWatchdog) *dbWriter {
+	w := &dbWriter{db: db, wd: wd, reqs: make(chan writeReq), done: make(chan struct{})}
+	go w.run()
+	return w
+}
+
+func (w *dbWriter) run() {
+	defer close(w.done)
+	for req := range w.reqs {
+		req.result <- w.wd.watch(func() error { return req.write(w.db) })
+	}
+}
+
+// do runs write on the writer goroutine and returns its error.
+func (w *dbWriter) do(write func(db *sql.DB) error) error {
+	result := make(chan error, 1)
+	w.reqs <- writeReq{write: write, result: result}
+	return <-result
+}
+
+// close stops the writer after the writes already handed to it.
+func (w *dbWriter) close() {
+	close(w.reqs)
+	<-w.done
+}